@@ -2,14 +2,25 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/credentials"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/guard"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/haproxy"
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/health"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/interfaces"
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/podwatcher"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/reconciler"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/slicewatcher"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/snapshot"
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/traefik"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -44,7 +55,14 @@ func main() {
 		"version", Version,
 		"build_time", BuildTime,
 		"vcs_ref", VCSRef,
-		"use_watch", cfg.UseWatch)
+		"discovery_mode", cfg.DiscoveryMode,
+		"load_balancer", cfg.LoadBalancer,
+		"use_watch", cfg.UseWatch,
+		"require_ready", cfg.RequireReady,
+		"terminating_policy", cfg.TerminatingPolicy,
+		"traefik_targets", cfg.TraefikTargets(),
+		"traefik_pod_labels", cfg.TraefikPodLabels,
+		"traefik_api_credentials", cfg.HasTraefikAPICredentials())
 
 	// Validate configuration
 	if validateErr := cfg.Validate(); validateErr != nil {
@@ -70,25 +88,88 @@ func main() {
 	defer cancel()
 
 	// Create components
-	traefikBackend := traefik.New(cfg)
-	watcher := podwatcher.New(
-		clientset,
-		cfg.PodNamespace,
-		cfg.PodLabels,
-		cfg.BackendPort,
-		cfg.UpdateInterval,
-		cfg.UseWatch,
+	var backend interfaces.LoadBalancerBackend
+	var fleet *traefik.Fleet
+	if cfg.LoadBalancer == config.LoadBalancerHAProxy {
+		backend = haproxy.New(cfg)
+	} else {
+		fleet = traefik.NewFleet(cfg, newTraefikTransport(cfg, clientset), cfg.TraefikTargets())
+		backend = fleet
+	}
+	groups := cfg.BackendGroups()
+	sources := make(map[string]discovery.Source, len(groups))
+	groupNames := make([]string, len(groups))
+	for i := range groups {
+		sources[groups[i].Name] = newGroupSource(cfg, &groups[i], clientset)
+		groupNames[i] = groups[i].Name
+	}
+	safetyGuard := guard.New(
+		discovery.NewDebouncer(discovery.NewMulti(sources), cfg.SettleWindow, cfg.MaxDelay),
+		groupNames,
+		guard.Policy{
+			MinBackends:    cfg.SafetyMinBackends,
+			MaxDropPercent: cfg.SafetyMaxDropPercent,
+			HoldTimeout:    cfg.SafetyHoldTimeout,
+		},
 	)
+	var watcher interfaces.PodWatcher = safetyGuard
+
+	// Keep the load balancer converged on the discovered backends
+	store := newSnapshotStore(cfg, clientset)
+	rec := reconciler.New(backend, cfg.ReconcileInterval, reconciler.Backoff{
+		Initial: cfg.RetryInitialBackoff,
+		Max:     cfg.RetryMaxBackoff,
+	})
+	if store != nil {
+		// Saved in the background, so a slow store doesn't hold up reconciling
+		saver := snapshot.NewSaver(store)
+		go saver.Run(ctx)
+		rec.OnApplied = func(_ context.Context, backends []discovery.Backend) {
+			var payload []byte
+			var weights map[string]snapshot.CanaryWeight
+			if fleet != nil {
+				payload = fleet.LastApplied()
+				weights = canaryWeights(groups, fleet)
+			}
+			saver.Save(&snapshot.Snapshot{
+				Backends:      backends,
+				Config:        payload,
+				CanaryWeights: weights,
+				SavedAt:       time.Now().UTC(),
+			})
+		}
+	}
 
 	// Create health check server
 	healthServer := health.NewServer(cfg.HealthCheckPort)
 
 	// Add health checkers
 	healthServer.AddChecker(health.NewKubernetesHealthChecker(func(ctx context.Context) error {
-		_, err := clientset.CoreV1().Pods(cfg.PodNamespace).List(ctx, metav1.ListOptions{Limit: 1})
-		return err
+		for i := range groups {
+			if err := checkGroupAccess(ctx, clientset, cfg, &groups[i]); err != nil {
+				return fmt.Errorf("group %s: %w", groups[i].Name, err)
+			}
+		}
+		return nil
 	}))
-	healthServer.AddChecker(health.NewTraefikHealthChecker(traefikBackend))
+	if cfg.AdminTokenFile != "" {
+		if _, err := health.ReadToken(cfg.AdminTokenFile); err != nil {
+			slog.Error("Invalid admin token", "error", err)
+			os.Exit(1)
+		}
+		healthServer.Handle("/guard/override", health.RequireToken(cfg.AdminTokenFile, safetyGuard.OverrideHandler()))
+		if fleet != nil {
+			healthServer.Handle("/canary", health.RequireToken(cfg.AdminTokenFile, fleet.CanaryHandler(rec.Resync)))
+		}
+	} else {
+		slog.Info("Admin endpoints disabled, set ADMIN_TOKEN_FILE to enable them")
+	}
+	if fleet != nil {
+		healthServer.AddChecker(health.NewTraefikHealthChecker(fleet))
+		healthServer.Handle("/targets", fleet.TargetsHandler())
+	} else {
+		healthServer.AddChecker(health.NewHAProxyHealthChecker(backend))
+	}
 
 	// Start health server
 	go func() {
@@ -97,15 +178,45 @@ func main() {
 		}
 	}()
 
+	// Follow the Traefik pods to push to
+	if fleet != nil && cfg.TraefikPodLabels != "" {
+		go watchTraefikPods(ctx, cfg, clientset, fleet, rec.Resync)
+	}
+
+	// Push the last known good configuration before discovery has caught up
+	if store != nil {
+		restoreSnapshot(ctx, store, backend, cfg.SnapshotRestoreTimeout, func(snap *snapshot.Snapshot) {
+			if fleet != nil {
+				restoreCanaryWeights(groups, fleet, snap.CanaryWeights)
+			}
+		})
+	}
+	go rec.Run(ctx)
+
 	// Start watching pods
 	backendsChan, errorsChan := watcher.Watch(ctx)
 
 	// Mark as ready after initial setup
 	healthServer.SetReady(true)
+	for i := range groups {
+		g := &groups[i]
+		slog.Info("Managing backend group",
+			"group", g.Name,
+			"discovery_mode", g.DiscoveryMode,
+			"labels", g.PodLabels,
+			"namespaces", g.Namespaces,
+			"namespace_selector", g.NamespaceSelector,
+			"service", g.DiscoveryService,
+			"protocol", g.Protocol,
+			"canary", g.Canary != nil,
+			"router", g.RouterName,
+			"traefik_service", g.ServiceName)
+	}
 	slog.Info("Application ready",
 		"namespace", cfg.PodNamespace,
-		"labels", cfg.PodLabels,
-		"health_port", cfg.HealthCheckPort)
+		"group_count", len(groups),
+		"health_port", cfg.HealthCheckPort,
+		"reconcile_interval", cfg.ReconcileInterval)
 
 	// Main event loop
 	for {
@@ -122,10 +233,7 @@ func main() {
 				slog.Info("Backends channel closed")
 				return
 			}
-			if err := traefikBackend.UpdateBackends(ctx, backends); err != nil {
-				slog.Error("Failed to update backends", "error", err,
-					"circuit_breaker_state", traefikBackend.CircuitBreakerStats()["state"])
-			}
+			rec.SetDesired(backends)
 
 		case err, ok := <-errorsChan:
 			if !ok {
@@ -137,6 +245,253 @@ func main() {
 	}
 }
 
+// newGroupSource creates a group's watcher, paired with a second watcher for
+// the canary selector when the group has one
+func newGroupSource(cfg *config.Config, group *config.Group, clientset kubernetes.Interface) discovery.Source {
+	stable := newWatcher(cfg, group, clientset)
+	if group.Canary == nil {
+		return stable
+	}
+
+	canaryGroup := *group
+	canaryGroup.PodLabels = group.Canary.PodLabels
+	canaryGroup.DiscoveryService = group.Canary.DiscoveryService
+	return discovery.NewCanarySplit(stable, newWatcher(cfg, &canaryGroup, clientset))
+}
+
+// newWatcher creates the backend watcher for a group's discovery mode
+func newWatcher(cfg *config.Config, group *config.Group, clientset kubernetes.Interface) discovery.Source {
+	filter := discovery.Filter{
+		RequireReady: cfg.RequireReady,
+		Terminating:  discovery.TerminatingPolicy(cfg.TerminatingPolicy),
+	}
+
+	if group.DiscoveryMode == config.DiscoveryModeEndpointSlices {
+		return slicewatcher.New(
+			clientset,
+			serviceNamespace(cfg, group),
+			group.DiscoveryService,
+			group.DiscoveryPortName,
+			filter,
+		)
+	}
+	return podwatcher.New(clientset, podwatcher.Options{
+		Namespaces:        group.Namespaces,
+		NamespaceSelector: group.NamespaceSelector,
+		LabelSelector:     group.PodLabels,
+		BackendPort:       group.BackendPort,
+		PortName:          group.BackendPortName,
+		PortAnnotation:    cfg.BackendPortAnnotation,
+		WeightAnnotation:  cfg.WeightAnnotation,
+		UpdateInterval:    cfg.UpdateInterval,
+		UseWatch:          cfg.UseWatch,
+		Filter:            filter,
+	})
+}
+
+// serviceNamespace returns the namespace of the Service followed in endpointslices mode
+func serviceNamespace(cfg *config.Config, group *config.Group) string {
+	if len(group.Namespaces) > 0 {
+		return group.Namespaces[0]
+	}
+	return cfg.PodNamespace
+}
+
+// checkGroupAccess verifies the Kubernetes API can serve a group's discovery requests
+func checkGroupAccess(ctx context.Context, clientset kubernetes.Interface, cfg *config.Config, group *config.Group) error {
+	if group.DiscoveryMode == config.DiscoveryModeEndpointSlices {
+		_, err := clientset.DiscoveryV1().EndpointSlices(serviceNamespace(cfg, group)).List(ctx, metav1.ListOptions{Limit: 1})
+		return err
+	}
+	if group.NamespaceSelector != "" {
+		if _, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+			return err
+		}
+		_, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{Limit: 1})
+		return err
+	}
+	for _, namespace := range group.Namespaces {
+		if _, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTraefikTransport creates the Traefik API transport, adding the configured credentials
+func newTraefikTransport(cfg *config.Config, clientset kubernetes.Interface) http.RoundTripper {
+	// Discovered pods are dialled by IP but present the API host's certificate
+	newBase := traefik.NewTransport
+	if cfg.TraefikPodLabels != "" {
+		newBase = traefik.WithServerName(cfg.TraefikAPIURL, newBase)
+	}
+	if !cfg.HasTraefikAPICredentials() {
+		return newBase(nil)
+	}
+
+	var source credentials.Source
+	if cfg.TraefikAPISecret != "" {
+		source = credentials.NewSecretSource(clientset, cfg.PodNamespace, cfg.TraefikAPISecret)
+	} else {
+		source = &credentials.FileSource{
+			Username:     cfg.TraefikAPIUsername,
+			PasswordFile: cfg.TraefikAPIPasswordFile,
+			TokenFile:    cfg.TraefikAPITokenFile,
+			CAFile:       cfg.TraefikAPICAFile,
+			CertFile:     cfg.TraefikAPICertFile,
+			KeyFile:      cfg.TraefikAPIKeyFile,
+		}
+	}
+	return credentials.NewTransport(source, cfg.TraefikAPICredentialsRefresh, newBase)
+}
+
+// watchTraefikPods keeps the fleet's targets on the ready Traefik pods and
+// resyncs whenever they change, so new pods are configured right away
+func watchTraefikPods(ctx context.Context, cfg *config.Config, clientset kubernetes.Interface, fleet *traefik.Fleet, resync func()) {
+	watcher := podwatcher.New(clientset, podwatcher.Options{
+		Namespaces:     []string{cfg.PodNamespace},
+		LabelSelector:  cfg.TraefikPodLabels,
+		BackendPort:    traefik.APIPort(cfg.TraefikAPIURL),
+		UpdateInterval: cfg.UpdateInterval,
+		UseWatch:       cfg.UseWatch,
+		Filter: discovery.Filter{
+			RequireReady: true,
+			Terminating:  discovery.TerminatingExclude,
+		},
+	})
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			slog.Error("Error closing Traefik pod watcher", "error", err)
+		}
+	}()
+
+	podsChan, errorsChan := watcher.Watch(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case pods, ok := <-podsChan:
+			if !ok {
+				return
+			}
+			urls, err := traefik.PodURLs(cfg.TraefikAPIURL, pods)
+			if err != nil {
+				slog.Error("Failed to address Traefik pods", "error", err)
+				continue
+			}
+			if fleet.SetTargets(urls) {
+				resync()
+			}
+
+		case err, ok := <-errorsChan:
+			if !ok {
+				return
+			}
+			slog.Error("Traefik pod watcher error", "error", err)
+		}
+	}
+}
+
+// newSnapshotStore creates the configured snapshot store, or nil when snapshots are disabled
+func newSnapshotStore(cfg *config.Config, clientset kubernetes.Interface) snapshot.Store {
+	switch {
+	case cfg.SnapshotFile != "":
+		return snapshot.NewFileStore(cfg.SnapshotFile)
+	case cfg.SnapshotConfigMap != "":
+		return snapshot.NewConfigMapStore(clientset, cfg.PodNamespace, cfg.SnapshotConfigMap)
+	default:
+		return nil
+	}
+}
+
+// restoreSnapshot loads the last known good snapshot and pushes it to the load
+// balancer, retrying until it is accepted or the timeout expires. The rendered
+// configuration is restored as is where the backend supports it. loaded is
+// called with the snapshot before it is pushed.
+func restoreSnapshot(ctx context.Context, store snapshot.Store, backend interfaces.LoadBalancerBackend, timeout time.Duration, loaded func(*snapshot.Snapshot)) {
+	restorer, canRestore := backend.(interface {
+		Restore(ctx context.Context, payload []byte) error
+	})
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	snap, err := store.Load(ctx)
+	if err != nil {
+		slog.Warn("Failed to load snapshot", "error", err)
+		return
+	}
+	if snap == nil {
+		slog.Info("No snapshot to restore")
+		return
+	}
+
+	slog.Info("Restoring last known good snapshot",
+		"backend_count", len(snap.Backends),
+		"saved_at", snap.SavedAt)
+	loaded(snap)
+
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		if canRestore && len(snap.Config) > 0 {
+			err = restorer.Restore(ctx, snap.Config)
+		} else {
+			err = backend.UpdateBackends(ctx, snap.Backends)
+		}
+		if err == nil {
+			return
+		}
+		slog.Warn("Failed to restore snapshot, retrying", "error", err)
+
+		select {
+		case <-ctx.Done():
+			slog.Error("Giving up restoring snapshot", "error", err)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// canaryWeights pairs the current canary weight of every group with its configured one
+func canaryWeights(groups []config.Group, fleet *traefik.Fleet) map[string]snapshot.CanaryWeight {
+	current := fleet.CanaryWeights()
+	weights := make(map[string]snapshot.CanaryWeight, len(current))
+	for i := range groups {
+		if weight, ok := current[groups[i].Name]; ok {
+			weights[groups[i].Name] = snapshot.CanaryWeight{
+				Configured: groups[i].Canary.Weight,
+				Current:    weight,
+			}
+		}
+	}
+	return weights
+}
+
+// restoreCanaryWeights brings back canary weights changed at runtime before a
+// restart. A saved weight is dropped when the group's configured weight
+// changed since, so a new CANARY_WEIGHT takes effect.
+func restoreCanaryWeights(groups []config.Group, fleet *traefik.Fleet, saved map[string]snapshot.CanaryWeight) {
+	for i := range groups {
+		g := &groups[i]
+		weight, ok := saved[g.Name]
+		if !ok || g.Canary == nil || weight.Current == g.Canary.Weight {
+			continue
+		}
+		if weight.Configured != g.Canary.Weight {
+			slog.Info("Configured canary weight changed, not restoring the saved one",
+				"group", g.Name,
+				"saved_weight", weight.Current,
+				"weight", g.Canary.Weight)
+			continue
+		}
+		if err := fleet.SetCanaryWeight(g.Name, weight.Current); err != nil {
+			slog.Warn("Failed to restore canary weight", "group", g.Name, "error", err)
+		}
+	}
+}
+
 // initLogger initializes the structured logger
 func initLogger(cfg *config.Config) {
 	var level slog.Level
@@ -6,19 +6,94 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Discovery modes
+const (
+	// DiscoveryModePods selects backends by listing pods that match PodLabels
+	DiscoveryModePods = "pods"
+	// DiscoveryModeEndpointSlices follows the EndpointSlices of DiscoveryService
+	DiscoveryModeEndpointSlices = "endpointslices"
+)
+
+// Load balancers the controller can drive
+const (
+	// LoadBalancerTraefik pushes a dynamic configuration to Traefik's REST provider
+	LoadBalancerTraefik = "traefik"
+	// LoadBalancerHAProxy fills server-template slots through HAProxy's Runtime API
+	LoadBalancerHAProxy = "haproxy"
 )
 
 // Config holds the application configuration
 type Config struct {
 	// Kubernetes configuration
-	PodLabels    string
-	PodNamespace string
+	PodLabels         string
+	PodNamespace      string
+	PodNamespaces     []string // Namespaces to discover pods in, defaults to PodNamespace
+	NamespaceSelector string   // Discover pods in all namespaces matching this label selector
+
+	// Discovery configuration
+	DiscoveryMode     string // pods or endpointslices
+	DiscoveryService  string // Service whose EndpointSlices are followed
+	DiscoveryPortName string // Service port name, empty selects the first port
+
+	// Backend filtering
+	RequireReady      bool   // Only route to backends whose Ready condition is true
+	TerminatingPolicy string // exclude, until-unready or include
+
+	// Load balancer to configure, traefik or haproxy
+	LoadBalancer string
+
+	// HAProxy Runtime API socket, a Unix socket path or host:port. Each group's
+	// ServiceName names the HAProxy backend holding its server-template slots.
+	HAProxyRuntimeAPI string
 
 	// Traefik configuration
 	TraefikAPIURL      string
 	LoadBalancerMethod string
 	RouterName         string
 	ServiceName        string
+	Protocol           string   // tcp, http or udp
+	EntryPoints        []string // Traefik entrypoints, defaults depend on Protocol
+	RouterRule         string   // Router rule, defaults to a catch-all for Protocol
+	RouterPriority     int      // Router priority, 0 leaves it to Traefik
+	PassHostHeader     bool     // Forward the client Host header in http mode
+
+	// Traefik instances to push to: a static list, or the ready pods matching
+	// TraefikPodLabels in PodNamespace, addressed like TraefikAPIURL with the
+	// host replaced by the pod IP. Empty uses TraefikAPIURL alone.
+	TraefikAPIURLs   []string
+	TraefikPodLabels string
+
+	// Traefik API credentials, read from files or a Secret in PodNamespace
+	// and reloaded every TraefikAPICredentialsRefresh
+	TraefikAPIUsername           string
+	TraefikAPIPasswordFile       string
+	TraefikAPITokenFile          string
+	TraefikAPICAFile             string
+	TraefikAPICertFile           string
+	TraefikAPIKeyFile            string
+	TraefikAPISecret             string
+	TraefikAPICredentialsRefresh time.Duration
+
+	// Merge updates into a REST provider shared with other writers instead
+	// of replacing it. Entries named with OwnerPrefix are owned by this
+	// instance and removed once no longer rendered.
+	MergeUpdates bool
+	OwnerPrefix  string
+
+	// Canary selector and its share of traffic in percent
+	CanaryPodLabels        string
+	CanaryDiscoveryService string
+	CanaryWeight           int
+
+	// Router TLS: SNI routing, passthrough or termination
+	SNIHosts        []string
+	TLSPassthrough  bool
+	TLSOptions      string // Name of a Traefik TLS options entry
+	TLSCertResolver string
 
 	// Health check configuration
 	HealthCheckPath string
@@ -34,6 +109,23 @@ type Config struct {
 
 	// Update configuration
 	UpdateInterval time.Duration
+	SettleWindow   time.Duration // Quiet period before pushing a burst of changes, 0 disables debouncing
+	MaxDelay       time.Duration // Upper bound on how long a burst may delay a push
+
+	// VerifyTimeout is how long to wait for Traefik to report a pushed
+	// configuration as applied, 0 disables verification
+	VerifyTimeout time.Duration
+
+	// ForcePushInterval is how old the last push may get before an unchanged
+	// configuration is pushed again, 0 only pushes changes
+	ForcePushInterval time.Duration
+
+	// ReconcileInterval is how often the live Traefik state is checked for drift, 0 disables
+	ReconcileInterval time.Duration
+
+	// Backoff between retries of a failed backend update
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
 
 	// Circuit breaker configuration
 	CBInterval time.Duration
@@ -43,50 +135,144 @@ type Config struct {
 	BackendPort     int
 	HealthCheckPort int
 
+	// Per-pod backend port resolution
+	BackendPortName       string // Named container port, pods without it are skipped
+	BackendPortAnnotation string // Pod annotation overriding the port (number or port name)
+
+	// WeightAnnotation is the pod annotation holding the backend weight
+	WeightAnnotation string
+
 	// Circuit breaker thresholds
 	CBMaxRequests         uint32
 	CBConsecutiveFailures uint32
 
 	// Update configuration
 	UseWatch bool // Use Kubernetes watch API instead of polling
+
+	// Mass-removal safety guard, disabled while both thresholds are 0
+	SafetyMinBackends    int           // Hold changes leaving a group with fewer backends
+	SafetyMaxDropPercent int           // Hold changes removing more than this share of a group at once
+	SafetyHoldTimeout    time.Duration // Accept a held change after this long, 0 holds until overridden
+
+	// AdminTokenFile holds the bearer token required by the admin endpoints
+	// on the health port; without it they aren't served
+	AdminTokenFile string
+
+	// Last known good snapshot, persisted to a file or a ConfigMap in PodNamespace
+	SnapshotFile           string
+	SnapshotConfigMap      string
+	SnapshotRestoreTimeout time.Duration
+
+	// Groups are the backend groups to manage, loaded from GroupsFile.
+	// When empty a single group is built from the settings above.
+	Groups     []Group
+	GroupsFile string
 }
 
 // LoadFromEnv loads configuration from environment variables
 func LoadFromEnv() (*Config, error) {
 	cfg := &Config{
 		// Defaults
-		BackendPort:           3333,
-		LoadBalancerMethod:    "leastconn",
-		RouterName:            "relay-router",
-		ServiceName:           "relay-service",
-		UpdateInterval:        time.Second,
-		UseWatch:              true,
-		HealthCheckPort:       8081,
-		HealthCheckPath:       "/health",
-		CBMaxRequests:         5,
-		CBInterval:            time.Minute,
-		CBTimeout:             30 * time.Second,
-		CBConsecutiveFailures: 5,
-		LogLevel:              "info",
-		LogFormat:             "json",
+		BackendPort:                  3333,
+		BackendPortAnnotation:        "ilb.tazhate.io/port",
+		WeightAnnotation:             "ilb.tazhate.io/weight",
+		DiscoveryMode:                DiscoveryModePods,
+		LoadBalancer:                 LoadBalancerTraefik,
+		RequireReady:                 true,
+		TerminatingPolicy:            "exclude",
+		LoadBalancerMethod:           "leastconn",
+		Protocol:                     ProtocolTCP,
+		PassHostHeader:               true,
+		RouterName:                   "relay-router",
+		ServiceName:                  "relay-service",
+		UpdateInterval:               time.Second,
+		SettleWindow:                 500 * time.Millisecond,
+		MaxDelay:                     5 * time.Second,
+		SnapshotRestoreTimeout:       30 * time.Second,
+		TraefikAPICredentialsRefresh: time.Minute,
+		ReconcileInterval:            30 * time.Second,
+		RetryInitialBackoff:          time.Second,
+		RetryMaxBackoff:              30 * time.Second,
+		SafetyHoldTimeout:            5 * time.Minute,
+		UseWatch:                     true,
+		HealthCheckPort:              8081,
+		HealthCheckPath:              "/health",
+		CBMaxRequests:                5,
+		CBInterval:                   time.Minute,
+		CBTimeout:                    30 * time.Second,
+		CBConsecutiveFailures:        5,
+		LogLevel:                     "info",
+		LogFormat:                    "json",
+	}
+
+	// Discovery mode
+	if mode := os.Getenv("DISCOVERY_MODE"); mode != "" {
+		cfg.DiscoveryMode = strings.ToLower(mode)
 	}
+	cfg.DiscoveryService = os.Getenv("DISCOVERY_SERVICE")
+	cfg.DiscoveryPortName = os.Getenv("DISCOVERY_PORT_NAME")
+
+	// Backend groups file replaces the single group selector
+	cfg.GroupsFile = os.Getenv("GROUPS_FILE")
 
 	// Required fields
 	cfg.PodLabels = os.Getenv("POD_LABELS")
-	if cfg.PodLabels == "" {
-		return nil, fmt.Errorf("POD_LABELS environment variable is required")
+	switch cfg.DiscoveryMode {
+	case DiscoveryModePods:
+		if cfg.PodLabels == "" && cfg.GroupsFile == "" {
+			return nil, fmt.Errorf("POD_LABELS environment variable is required")
+		}
+	case DiscoveryModeEndpointSlices:
+		if cfg.DiscoveryService == "" && cfg.GroupsFile == "" {
+			return nil, fmt.Errorf("DISCOVERY_SERVICE environment variable is required in endpointslices mode")
+		}
+	default:
+		return nil, fmt.Errorf("invalid DISCOVERY_MODE %q: must be %q or %q",
+			cfg.DiscoveryMode, DiscoveryModePods, DiscoveryModeEndpointSlices)
+	}
+
+	if lb := os.Getenv("LOAD_BALANCER"); lb != "" {
+		cfg.LoadBalancer = strings.ToLower(lb)
 	}
+	cfg.HAProxyRuntimeAPI = os.Getenv("HAPROXY_RUNTIME_API")
 
 	cfg.TraefikAPIURL = os.Getenv("TRAEFIK_API_URL")
-	if cfg.TraefikAPIURL == "" {
+	cfg.TraefikAPIURLs = splitList(os.Getenv("TRAEFIK_API_URLS"))
+	if cfg.TraefikAPIURL == "" && len(cfg.TraefikAPIURLs) > 0 {
+		cfg.TraefikAPIURL = cfg.TraefikAPIURLs[0]
+	}
+	if cfg.TraefikAPIURL == "" && cfg.LoadBalancer == LoadBalancerTraefik {
 		return nil, fmt.Errorf("TRAEFIK_API_URL environment variable is required")
 	}
+	cfg.TraefikPodLabels = os.Getenv("TRAEFIK_POD_LABELS")
 
 	// Validate Traefik API URL
 	if _, err := url.Parse(cfg.TraefikAPIURL); err != nil {
 		return nil, fmt.Errorf("invalid TRAEFIK_API_URL: %w", err)
 	}
 
+	// Optional: Merge into a shared REST provider
+	if mergeStr := os.Getenv("MERGE_UPDATES"); mergeStr != "" {
+		cfg.MergeUpdates = mergeStr == "true" || mergeStr == "1"
+	}
+	cfg.OwnerPrefix = os.Getenv("OWNER_PREFIX")
+
+	// Optional: Traefik API credentials
+	cfg.TraefikAPIUsername = os.Getenv("TRAEFIK_API_USERNAME")
+	cfg.TraefikAPIPasswordFile = os.Getenv("TRAEFIK_API_PASSWORD_FILE")
+	cfg.TraefikAPITokenFile = os.Getenv("TRAEFIK_API_TOKEN_FILE")
+	cfg.TraefikAPICAFile = os.Getenv("TRAEFIK_API_CA_FILE")
+	cfg.TraefikAPICertFile = os.Getenv("TRAEFIK_API_CERT_FILE")
+	cfg.TraefikAPIKeyFile = os.Getenv("TRAEFIK_API_KEY_FILE")
+	cfg.TraefikAPISecret = os.Getenv("TRAEFIK_API_SECRET")
+	if refreshStr := os.Getenv("TRAEFIK_API_CREDENTIALS_REFRESH"); refreshStr != "" {
+		refresh, err := time.ParseDuration(refreshStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRAEFIK_API_CREDENTIALS_REFRESH: %w", err)
+		}
+		cfg.TraefikAPICredentialsRefresh = refresh
+	}
+
 	// Namespace
 	cfg.PodNamespace = os.Getenv("POD_NAMESPACE")
 	if cfg.PodNamespace == "" {
@@ -100,6 +286,15 @@ func LoadFromEnv() (*Config, error) {
 		}
 	}
 
+	// Optional: Multi-namespace discovery
+	if namespaces := os.Getenv("POD_NAMESPACES"); namespaces != "" {
+		cfg.PodNamespaces = splitList(namespaces)
+	}
+	if len(cfg.PodNamespaces) == 0 {
+		cfg.PodNamespaces = []string{cfg.PodNamespace}
+	}
+	cfg.NamespaceSelector = os.Getenv("NAMESPACE_SELECTOR")
+
 	// Optional: Update interval
 	if intervalStr := os.Getenv("UPDATE_INTERVAL"); intervalStr != "" {
 		interval, err := time.ParseDuration(intervalStr)
@@ -109,11 +304,113 @@ func LoadFromEnv() (*Config, error) {
 		cfg.UpdateInterval = interval
 	}
 
+	// Optional: Debouncing of backend changes
+	if settleStr := os.Getenv("SETTLE_WINDOW"); settleStr != "" {
+		settle, err := time.ParseDuration(settleStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SETTLE_WINDOW: %w", err)
+		}
+		cfg.SettleWindow = settle
+	}
+	if maxDelayStr := os.Getenv("MAX_DELAY"); maxDelayStr != "" {
+		maxDelay, err := time.ParseDuration(maxDelayStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_DELAY: %w", err)
+		}
+		cfg.MaxDelay = maxDelay
+	}
+
+	// Optional: Periodic reconciliation
+	if reconcileStr := os.Getenv("RECONCILE_INTERVAL"); reconcileStr != "" {
+		reconcile, err := time.ParseDuration(reconcileStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECONCILE_INTERVAL: %w", err)
+		}
+		cfg.ReconcileInterval = reconcile
+	}
+
+	// Optional: Read-after-write verification
+	if verifyStr := os.Getenv("VERIFY_TIMEOUT"); verifyStr != "" {
+		verify, err := time.ParseDuration(verifyStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VERIFY_TIMEOUT: %w", err)
+		}
+		cfg.VerifyTimeout = verify
+	}
+
+	// Optional: Forced push of an unchanged configuration
+	if forceStr := os.Getenv("FORCE_PUSH_INTERVAL"); forceStr != "" {
+		force, err := time.ParseDuration(forceStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FORCE_PUSH_INTERVAL: %w", err)
+		}
+		cfg.ForcePushInterval = force
+	}
+
+	// Optional: Retry backoff
+	if initialStr := os.Getenv("RETRY_INITIAL_BACKOFF"); initialStr != "" {
+		initial, err := time.ParseDuration(initialStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETRY_INITIAL_BACKOFF: %w", err)
+		}
+		cfg.RetryInitialBackoff = initial
+	}
+	if maxStr := os.Getenv("RETRY_MAX_BACKOFF"); maxStr != "" {
+		maxBackoff, err := time.ParseDuration(maxStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RETRY_MAX_BACKOFF: %w", err)
+		}
+		cfg.RetryMaxBackoff = maxBackoff
+	}
+
+	// Optional: Mass-removal safety guard
+	if minStr := os.Getenv("SAFETY_MIN_BACKENDS"); minStr != "" {
+		if _, err := fmt.Sscanf(minStr, "%d", &cfg.SafetyMinBackends); err != nil {
+			return nil, fmt.Errorf("invalid SAFETY_MIN_BACKENDS: %w", err)
+		}
+	}
+	if dropStr := os.Getenv("SAFETY_MAX_DROP_PERCENT"); dropStr != "" {
+		if _, err := fmt.Sscanf(dropStr, "%d", &cfg.SafetyMaxDropPercent); err != nil {
+			return nil, fmt.Errorf("invalid SAFETY_MAX_DROP_PERCENT: %w", err)
+		}
+	}
+	if holdStr := os.Getenv("SAFETY_HOLD_TIMEOUT"); holdStr != "" {
+		hold, err := time.ParseDuration(holdStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SAFETY_HOLD_TIMEOUT: %w", err)
+		}
+		cfg.SafetyHoldTimeout = hold
+	}
+
+	// Optional: Admin endpoints
+	cfg.AdminTokenFile = os.Getenv("ADMIN_TOKEN_FILE")
+
+	// Optional: Last known good snapshot
+	cfg.SnapshotFile = os.Getenv("SNAPSHOT_FILE")
+	cfg.SnapshotConfigMap = os.Getenv("SNAPSHOT_CONFIGMAP")
+	if timeoutStr := os.Getenv("SNAPSHOT_RESTORE_TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNAPSHOT_RESTORE_TIMEOUT: %w", err)
+		}
+		cfg.SnapshotRestoreTimeout = timeout
+	}
+
 	// Optional: Use watch API
 	if useWatchStr := os.Getenv("USE_WATCH"); useWatchStr != "" {
 		cfg.UseWatch = useWatchStr == "true" || useWatchStr == "1"
 	}
 
+	// Optional: Readiness filtering
+	if requireReadyStr := os.Getenv("REQUIRE_READY"); requireReadyStr != "" {
+		cfg.RequireReady = requireReadyStr == "true" || requireReadyStr == "1"
+	}
+
+	// Optional: Terminating backend policy
+	if policy := os.Getenv("TERMINATING_POLICY"); policy != "" {
+		cfg.TerminatingPolicy = strings.ToLower(policy)
+	}
+
 	// Optional: Backend port
 	if portStr := os.Getenv("BACKEND_PORT"); portStr != "" {
 		var port int
@@ -126,6 +423,63 @@ func LoadFromEnv() (*Config, error) {
 		cfg.BackendPort = port
 	}
 
+	// Optional: Named container port
+	cfg.BackendPortName = os.Getenv("BACKEND_PORT_NAME")
+
+	// Optional: Port override annotation
+	if annotation, ok := os.LookupEnv("BACKEND_PORT_ANNOTATION"); ok {
+		cfg.BackendPortAnnotation = annotation
+	}
+
+	// Optional: Backend weight annotation
+	if annotation, ok := os.LookupEnv("WEIGHT_ANNOTATION"); ok {
+		cfg.WeightAnnotation = annotation
+	}
+
+	// Optional: Traefik protocol
+	if protocol := os.Getenv("PROTOCOL"); protocol != "" {
+		cfg.Protocol = strings.ToLower(protocol)
+	}
+	if passHostStr := os.Getenv("PASS_HOST_HEADER"); passHostStr != "" {
+		cfg.PassHostHeader = passHostStr == "true" || passHostStr == "1"
+	}
+
+	// Optional: Traefik router and service
+	if entryPoints := os.Getenv("ENTRYPOINTS"); entryPoints != "" {
+		cfg.EntryPoints = splitList(entryPoints)
+	}
+	cfg.RouterRule = os.Getenv("ROUTER_RULE")
+	if priorityStr := os.Getenv("ROUTER_PRIORITY"); priorityStr != "" {
+		if _, err := fmt.Sscanf(priorityStr, "%d", &cfg.RouterPriority); err != nil {
+			return nil, fmt.Errorf("invalid ROUTER_PRIORITY: %w", err)
+		}
+	}
+	if name := os.Getenv("ROUTER_NAME"); name != "" {
+		cfg.RouterName = name
+	}
+	if name := os.Getenv("SERVICE_NAME"); name != "" {
+		cfg.ServiceName = name
+	}
+
+	// Optional: Canary split
+	cfg.CanaryPodLabels = os.Getenv("CANARY_POD_LABELS")
+	cfg.CanaryDiscoveryService = os.Getenv("CANARY_DISCOVERY_SERVICE")
+	if weightStr := os.Getenv("CANARY_WEIGHT"); weightStr != "" {
+		if _, err := fmt.Sscanf(weightStr, "%d", &cfg.CanaryWeight); err != nil {
+			return nil, fmt.Errorf("invalid CANARY_WEIGHT: %w", err)
+		}
+	}
+
+	// Optional: Router TLS
+	if hostsStr := os.Getenv("SNI_HOSTS"); hostsStr != "" {
+		cfg.SNIHosts = splitList(hostsStr)
+	}
+	if passthroughStr := os.Getenv("TLS_PASSTHROUGH"); passthroughStr != "" {
+		cfg.TLSPassthrough = passthroughStr == "true" || passthroughStr == "1"
+	}
+	cfg.TLSOptions = os.Getenv("TLS_OPTIONS")
+	cfg.TLSCertResolver = os.Getenv("TLS_CERT_RESOLVER")
+
 	// Optional: Load balancer method
 	if method := os.Getenv("LB_METHOD"); method != "" {
 		cfg.LoadBalancerMethod = method
@@ -141,16 +495,22 @@ func LoadFromEnv() (*Config, error) {
 		cfg.LogFormat = strings.ToLower(format)
 	}
 
+	// Optional: Backend groups
+	if cfg.GroupsFile != "" {
+		groups, err := cfg.loadGroupsFile(cfg.GroupsFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GROUPS_FILE: %w", err)
+		}
+		cfg.Groups = groups
+	}
+
 	return cfg, nil
 }
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if c.PodLabels == "" {
-		return fmt.Errorf("PodLabels is required")
-	}
-	if c.TraefikAPIURL == "" {
-		return fmt.Errorf("TraefikAPIURL is required")
+	if err := c.validateLoadBalancer(); err != nil {
+		return err
 	}
 	if c.PodNamespace == "" {
 		return fmt.Errorf("PodNamespace is required")
@@ -161,5 +521,187 @@ func (c *Config) Validate() error {
 	if c.UpdateInterval < time.Second {
 		return fmt.Errorf("UpdateInterval must be at least 1 second")
 	}
+	if c.SettleWindow < 0 {
+		return fmt.Errorf("SettleWindow must not be negative")
+	}
+	if c.SettleWindow > 0 && c.MaxDelay < c.SettleWindow {
+		return fmt.Errorf("MaxDelay must be at least SettleWindow")
+	}
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("ReconcileInterval must not be negative")
+	}
+	if c.ForcePushInterval < 0 {
+		return fmt.Errorf("ForcePushInterval must not be negative")
+	}
+	if c.VerifyTimeout < 0 {
+		return fmt.Errorf("VerifyTimeout must not be negative")
+	}
+	if c.RetryInitialBackoff < 0 || c.RetryMaxBackoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+	if c.RetryMaxBackoff > 0 && c.RetryMaxBackoff < c.RetryInitialBackoff {
+		return fmt.Errorf("RetryMaxBackoff must be at least RetryInitialBackoff")
+	}
+	if c.SafetyMinBackends < 0 {
+		return fmt.Errorf("SafetyMinBackends must not be negative")
+	}
+	if c.SafetyMaxDropPercent < 0 || c.SafetyMaxDropPercent > 100 {
+		return fmt.Errorf("SafetyMaxDropPercent must be between 0 and 100")
+	}
+	if c.SafetyHoldTimeout < 0 {
+		return fmt.Errorf("SafetyHoldTimeout must not be negative")
+	}
+	if c.RouterPriority < 0 {
+		return fmt.Errorf("RouterPriority must not be negative")
+	}
+	if c.RouterName != "" && c.RouterName == c.ServiceName {
+		return fmt.Errorf("RouterName and ServiceName must differ")
+	}
+	if err := c.validateTraefikTargets(); err != nil {
+		return err
+	}
+	if err := c.validateTraefikAPICredentials(); err != nil {
+		return err
+	}
+	if c.SnapshotFile != "" && c.SnapshotConfigMap != "" {
+		return fmt.Errorf("only one of SnapshotFile and SnapshotConfigMap may be set")
+	}
+	switch c.TerminatingPolicy {
+	case "", "exclude", "until-unready", "include":
+	default:
+		return fmt.Errorf("TerminatingPolicy must be one of exclude, until-unready, include")
+	}
+	if len(c.Groups) == 0 {
+		// The default group carries the discovery settings validated above
+		g := c.DefaultGroup()
+		if err := g.Validate(); err != nil {
+			return err
+		}
+	} else if err := validateGroups(c.Groups); err != nil {
+		return err
+	}
+	return c.validateOwnership()
+}
+
+// validateOwnership checks that merging instances have an owner prefix and
+// that every rendered router and service carries it
+func (c *Config) validateOwnership() error {
+	if c.OwnerPrefix == "" {
+		if c.MergeUpdates {
+			// Without it a restarted instance can't tell its old entries from other writers'
+			return fmt.Errorf("MergeUpdates requires OwnerPrefix")
+		}
+		return nil
+	}
+	if !c.MergeUpdates {
+		return fmt.Errorf("OwnerPrefix requires MergeUpdates")
+	}
+	if !validName(c.OwnerPrefix) {
+		return fmt.Errorf("invalid OwnerPrefix %q", c.OwnerPrefix)
+	}
+	for _, g := range c.BackendGroups() {
+		for _, name := range []string{g.RouterName, g.ServiceName} {
+			if !strings.HasPrefix(name, c.OwnerPrefix) {
+				return fmt.Errorf("group %s: %q doesn't start with OwnerPrefix %q", g.Name, name, c.OwnerPrefix)
+			}
+		}
+	}
+	return nil
+}
+
+// validateLoadBalancer checks the settings of the selected load balancer
+func (c *Config) validateLoadBalancer() error {
+	switch c.LoadBalancer {
+	case "", LoadBalancerTraefik:
+		if c.TraefikAPIURL == "" {
+			return fmt.Errorf("TraefikAPIURL is required")
+		}
+		return nil
+	case LoadBalancerHAProxy:
+	default:
+		return fmt.Errorf("LoadBalancer must be %q or %q", LoadBalancerTraefik, LoadBalancerHAProxy)
+	}
+
+	if c.HAProxyRuntimeAPI == "" {
+		return fmt.Errorf("HAProxyRuntimeAPI is required")
+	}
+	if c.MergeUpdates || len(c.TraefikAPIURLs) > 0 || c.TraefikPodLabels != "" {
+		return fmt.Errorf("MergeUpdates, TraefikAPIURLs and TraefikPodLabels require the %s load balancer", LoadBalancerTraefik)
+	}
+	for _, g := range c.BackendGroups() {
+		if g.Canary != nil {
+			return fmt.Errorf("group %s: canary splitting requires the %s load balancer", g.Name, LoadBalancerTraefik)
+		}
+	}
+	return nil
+}
+
+// validateTraefikTargets checks the Traefik instances to push to
+func (c *Config) validateTraefikTargets() error {
+	if len(c.TraefikAPIURLs) > 0 && c.TraefikPodLabels != "" {
+		return fmt.Errorf("only one of TraefikAPIURLs and TraefikPodLabels may be set")
+	}
+	for _, apiURL := range c.TraefikAPIURLs {
+		u, err := url.Parse(apiURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid Traefik API URL %q", apiURL)
+		}
+	}
+	if c.TraefikPodLabels != "" {
+		if _, err := labels.Parse(c.TraefikPodLabels); err != nil {
+			return fmt.Errorf("invalid TraefikPodLabels: %w", err)
+		}
+	}
 	return nil
 }
+
+// TraefikTargets returns the static Traefik REST provider URLs to push to
+func (c *Config) TraefikTargets() []string {
+	if len(c.TraefikAPIURLs) > 0 {
+		return c.TraefikAPIURLs
+	}
+	if c.TraefikPodLabels != "" {
+		// Discovered at runtime
+		return nil
+	}
+	return []string{c.TraefikAPIURL}
+}
+
+// validateTraefikAPICredentials checks that the credential settings are complete and unambiguous
+func (c *Config) validateTraefikAPICredentials() error {
+	if c.TraefikAPICredentialsRefresh < 0 {
+		return fmt.Errorf("TraefikAPICredentialsRefresh must not be negative")
+	}
+	files := c.TraefikAPIUsername != "" || c.TraefikAPIPasswordFile != "" || c.TraefikAPITokenFile != "" ||
+		c.TraefikAPICAFile != "" || c.TraefikAPICertFile != "" || c.TraefikAPIKeyFile != ""
+	if c.TraefikAPISecret != "" && files {
+		return fmt.Errorf("TraefikAPISecret can't be combined with Traefik API credential files")
+	}
+	if (c.TraefikAPIUsername == "") != (c.TraefikAPIPasswordFile == "") {
+		return fmt.Errorf("TraefikAPIUsername and TraefikAPIPasswordFile must be set together")
+	}
+	if c.TraefikAPIUsername != "" && c.TraefikAPITokenFile != "" {
+		return fmt.Errorf("only one of basic auth and TraefikAPITokenFile may be set")
+	}
+	if (c.TraefikAPICertFile == "") != (c.TraefikAPIKeyFile == "") {
+		return fmt.Errorf("TraefikAPICertFile and TraefikAPIKeyFile must be set together")
+	}
+	return nil
+}
+
+// HasTraefikAPICredentials reports whether any Traefik API credentials are configured
+func (c *Config) HasTraefikAPICredentials() bool {
+	return c.TraefikAPISecret != "" || c.TraefikAPIUsername != "" || c.TraefikAPITokenFile != "" ||
+		c.TraefikAPICAFile != "" || c.TraefikAPICertFile != ""
+}
+
+// splitList splits a comma-separated list, dropping blanks
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
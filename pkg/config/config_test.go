@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"slices"
 	"testing"
 	"time"
 )
@@ -47,6 +48,121 @@ func TestLoadFromEnv(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "endpointslices mode without POD_LABELS",
+			env: map[string]string{
+				"TRAEFIK_API_URL":   "http://localhost:8080/api",
+				"POD_NAMESPACE":     "default",
+				"DISCOVERY_MODE":    "endpointslices",
+				"DISCOVERY_SERVICE": "relay",
+			},
+			wantErr: false,
+		},
+		{
+			name: "endpointslices mode without DISCOVERY_SERVICE",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"DISCOVERY_MODE":  "endpointslices",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid DISCOVERY_MODE",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"DISCOVERY_MODE":  "services",
+			},
+			wantErr: true,
+		},
+		{
+			name: "multiple namespaces",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"POD_NAMESPACES":  "tenant-a, tenant-b",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid SAFETY_MIN_BACKENDS",
+			env: map[string]string{
+				"POD_LABELS":          "app=test",
+				"TRAEFIK_API_URL":     "http://localhost:8080/api",
+				"POD_NAMESPACE":       "default",
+				"SAFETY_MIN_BACKENDS": "few",
+			},
+			wantErr: true,
+		},
+		{
+			name: "http protocol",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"PROTOCOL":        "HTTP",
+			},
+			wantErr: false,
+		},
+		{
+			name: "SNI routing with passthrough",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"SNI_HOSTS":       "relay.example.com",
+				"TLS_PASSTHROUGH": "true",
+			},
+			wantErr: false,
+		},
+		{
+			name: "custom router settings",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"ENTRYPOINTS":     "relay, relay-alt",
+				"ROUTER_RULE":     "HostSNI(`*`)",
+				"ROUTER_PRIORITY": "10",
+				"ROUTER_NAME":     "my-router",
+				"SERVICE_NAME":    "my-service",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid ROUTER_PRIORITY",
+			env: map[string]string{
+				"POD_LABELS":      "app=test",
+				"TRAEFIK_API_URL": "http://localhost:8080/api",
+				"POD_NAMESPACE":   "default",
+				"ROUTER_PRIORITY": "high",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Traefik pods discovered by label",
+			env: map[string]string{
+				"POD_LABELS":         "app=test",
+				"TRAEFIK_API_URL":    "http://traefik:8080/api",
+				"POD_NAMESPACE":      "default",
+				"TRAEFIK_POD_LABELS": "app.kubernetes.io/name=traefik",
+			},
+			wantErr: false,
+		},
+		{
+			name: "haproxy without TRAEFIK_API_URL",
+			env: map[string]string{
+				"POD_LABELS":          "app=test",
+				"POD_NAMESPACE":       "default",
+				"LOAD_BALANCER":       "haproxy",
+				"HAPROXY_RUNTIME_API": "/var/run/haproxy.sock",
+			},
+			wantErr: false,
+		},
 		{
 			name: "valid UPDATE_INTERVAL",
 			env: map[string]string{
@@ -76,12 +192,21 @@ func TestLoadFromEnv(t *testing.T) {
 			}
 
 			if err == nil {
+				if len(cfg.PodNamespaces) == 0 {
+					t.Error("PodNamespaces should default to PodNamespace")
+				}
 				if cfg.PodLabels != tt.env["POD_LABELS"] {
 					t.Errorf("PodLabels = %v, want %v", cfg.PodLabels, tt.env["POD_LABELS"])
 				}
 				if cfg.TraefikAPIURL != tt.env["TRAEFIK_API_URL"] {
 					t.Errorf("TraefikAPIURL = %v, want %v", cfg.TraefikAPIURL, tt.env["TRAEFIK_API_URL"])
 				}
+				if name := tt.env["ROUTER_NAME"]; name != "" && cfg.RouterName != name {
+					t.Errorf("RouterName = %v, want %v", cfg.RouterName, name)
+				}
+				if err := cfg.Validate(); err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
 			}
 		})
 	}
@@ -114,6 +239,346 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "endpointslices mode",
+			cfg: &Config{
+				DiscoveryMode:    DiscoveryModeEndpointSlices,
+				DiscoveryService: "relay",
+				TraefikAPIURL:    "http://localhost:8080/api",
+				PodNamespace:     "default",
+				BackendPort:      3333,
+				UpdateInterval:   time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid TerminatingPolicy",
+			cfg: &Config{
+				PodLabels:         "app=test",
+				TraefikAPIURL:     "http://localhost:8080/api",
+				PodNamespace:      "default",
+				BackendPort:       3333,
+				UpdateInterval:    time.Second,
+				TerminatingPolicy: "drain",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid NamespaceSelector",
+			cfg: &Config{
+				PodLabels:         "app=test",
+				TraefikAPIURL:     "http://localhost:8080/api",
+				PodNamespace:      "default",
+				NamespaceSelector: "tenant in (",
+				BackendPort:       3333,
+				UpdateInterval:    time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "MaxDelay shorter than SettleWindow",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				SettleWindow:   2 * time.Second,
+				MaxDelay:       time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "SafetyMaxDropPercent over 100",
+			cfg: &Config{
+				PodLabels:            "app=test",
+				TraefikAPIURL:        "http://localhost:8080/api",
+				PodNamespace:         "default",
+				BackendPort:          3333,
+				UpdateInterval:       time.Second,
+				SafetyMaxDropPercent: 150,
+			},
+			wantErr: true,
+		},
+		{
+			name: "RetryMaxBackoff shorter than RetryInitialBackoff",
+			cfg: &Config{
+				PodLabels:           "app=test",
+				TraefikAPIURL:       "http://localhost:8080/api",
+				PodNamespace:        "default",
+				BackendPort:         3333,
+				UpdateInterval:      time.Second,
+				RetryInitialBackoff: 10 * time.Second,
+				RetryMaxBackoff:     time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid EntryPoints",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				EntryPoints:    []string{"tcp@internal"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid RouterRule",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				RouterRule:     "PathPrefix(`/`)",
+			},
+			wantErr: true,
+		},
+		{
+			name: "RouterName equal to ServiceName",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				RouterName:     "relay",
+				ServiceName:    "relay",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Traefik API basic auth",
+			cfg: &Config{
+				PodLabels:              "app=test",
+				TraefikAPIURL:          "https://traefik:8443/api/providers/rest",
+				PodNamespace:           "default",
+				BackendPort:            3333,
+				UpdateInterval:         time.Second,
+				TraefikAPIUsername:     "ilb",
+				TraefikAPIPasswordFile: "/etc/traefik-api/password",
+				TraefikAPICAFile:       "/etc/traefik-api/ca.crt",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Traefik API username without password",
+			cfg: &Config{
+				PodLabels:          "app=test",
+				TraefikAPIURL:      "http://localhost:8080/api",
+				PodNamespace:       "default",
+				BackendPort:        3333,
+				UpdateInterval:     time.Second,
+				TraefikAPIUsername: "ilb",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Traefik API client certificate without key",
+			cfg: &Config{
+				PodLabels:          "app=test",
+				TraefikAPIURL:      "http://localhost:8080/api",
+				PodNamespace:       "default",
+				BackendPort:        3333,
+				UpdateInterval:     time.Second,
+				TraefikAPICertFile: "/etc/traefik-api/tls.crt",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Traefik API secret combined with files",
+			cfg: &Config{
+				PodLabels:           "app=test",
+				TraefikAPIURL:       "http://localhost:8080/api",
+				PodNamespace:        "default",
+				BackendPort:         3333,
+				UpdateInterval:      time.Second,
+				TraefikAPISecret:    "traefik-api",
+				TraefikAPITokenFile: "/etc/traefik-api/token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "OwnerPrefix on every name",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				MergeUpdates:   true,
+				OwnerPrefix:    "team-a-",
+				RouterName:     "team-a-router",
+				ServiceName:    "team-a-service",
+			},
+			wantErr: false,
+		},
+		{
+			name: "OwnerPrefix missing from a name",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				MergeUpdates:   true,
+				OwnerPrefix:    "team-a-",
+				RouterName:     "team-a-router",
+				ServiceName:    "relay-service",
+			},
+			wantErr: true,
+		},
+		{
+			name: "MergeUpdates without OwnerPrefix",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				MergeUpdates:   true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "OwnerPrefix without MergeUpdates",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				OwnerPrefix:    "team-a-",
+				RouterName:     "team-a-router",
+				ServiceName:    "team-a-service",
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative ForcePushInterval",
+			cfg: &Config{
+				PodLabels:         "app=test",
+				TraefikAPIURL:     "http://localhost:8080/api",
+				PodNamespace:      "default",
+				BackendPort:       3333,
+				UpdateInterval:    time.Second,
+				ForcePushInterval: -time.Minute,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative VerifyTimeout",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+				VerifyTimeout:  -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid TraefikAPIURLs",
+			cfg: &Config{
+				PodLabels:      "app=test",
+				TraefikAPIURL:  "http://traefik-a:8080/api",
+				TraefikAPIURLs: []string{"http://traefik-a:8080/api", "traefik-b"},
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "both TraefikAPIURLs and TraefikPodLabels",
+			cfg: &Config{
+				PodLabels:        "app=test",
+				TraefikAPIURL:    "http://traefik-a:8080/api",
+				TraefikAPIURLs:   []string{"http://traefik-a:8080/api", "http://traefik-b:8080/api"},
+				TraefikPodLabels: "app.kubernetes.io/name=traefik",
+				PodNamespace:     "default",
+				BackendPort:      3333,
+				UpdateInterval:   time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid TraefikPodLabels",
+			cfg: &Config{
+				PodLabels:        "app=test",
+				TraefikAPIURL:    "http://traefik:8080/api",
+				TraefikPodLabels: "app in (",
+				PodNamespace:     "default",
+				BackendPort:      3333,
+				UpdateInterval:   time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "haproxy load balancer",
+			cfg: &Config{
+				LoadBalancer:      LoadBalancerHAProxy,
+				HAProxyRuntimeAPI: "/var/run/haproxy.sock",
+				PodLabels:         "app=test",
+				PodNamespace:      "default",
+				BackendPort:       3333,
+				UpdateInterval:    time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "haproxy without HAProxyRuntimeAPI",
+			cfg: &Config{
+				LoadBalancer:   LoadBalancerHAProxy,
+				PodLabels:      "app=test",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "haproxy with a canary",
+			cfg: &Config{
+				LoadBalancer:      LoadBalancerHAProxy,
+				HAProxyRuntimeAPI: "/var/run/haproxy.sock",
+				PodLabels:         "app=test",
+				CanaryPodLabels:   "app=test,track=canary",
+				PodNamespace:      "default",
+				BackendPort:       3333,
+				UpdateInterval:    time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown LoadBalancer",
+			cfg: &Config{
+				LoadBalancer:   "nginx",
+				TraefikAPIURL:  "http://localhost:8080/api",
+				PodLabels:      "app=test",
+				PodNamespace:   "default",
+				BackendPort:    3333,
+				UpdateInterval: time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "both snapshot stores",
+			cfg: &Config{
+				PodLabels:         "app=test",
+				TraefikAPIURL:     "http://localhost:8080/api",
+				PodNamespace:      "default",
+				BackendPort:       3333,
+				UpdateInterval:    time.Second,
+				SnapshotFile:      "/var/lib/ilb/snapshot.json",
+				SnapshotConfigMap: "ilb-snapshot",
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid BackendPort",
 			cfg: &Config{
@@ -147,3 +612,28 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadFromEnvTraefikAPIURLs(t *testing.T) {
+	os.Clearenv()
+	os.Setenv("POD_LABELS", "app=test")
+	os.Setenv("POD_NAMESPACE", "default")
+	os.Setenv("TRAEFIK_API_URLS", "http://traefik-a:8080/api, http://traefik-b:8080/api")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv() error = %v", err)
+	}
+	want := []string{"http://traefik-a:8080/api", "http://traefik-b:8080/api"}
+	if !slices.Equal(cfg.TraefikTargets(), want) {
+		t.Errorf("TraefikTargets() = %v, want %v", cfg.TraefikTargets(), want)
+	}
+	if cfg.TraefikAPIURL != want[0] {
+		t.Errorf("TraefikAPIURL = %v, want the first target", cfg.TraefikAPIURL)
+	}
+
+	cfg.TraefikAPIURLs = nil
+	cfg.TraefikPodLabels = "app.kubernetes.io/name=traefik"
+	if targets := cfg.TraefikTargets(); targets != nil {
+		t.Errorf("TraefikTargets() = %v, want none until pods are discovered", targets)
+	}
+}
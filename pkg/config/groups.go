@@ -0,0 +1,478 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultGroupName is the name of the group built from environment variables
+const DefaultGroupName = "default"
+
+// Protocols a group can be rendered as
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolHTTP = "http"
+	ProtocolUDP  = "udp"
+)
+
+// defaultEntryPoints returns the entrypoints a protocol is served on by default
+func defaultEntryPoints(protocol string) []string {
+	switch protocol {
+	case ProtocolHTTP:
+		return []string{"web"}
+	case ProtocolUDP:
+		return []string{"udp"}
+	default:
+		return []string{"tcp"}
+	}
+}
+
+// sniRule builds a TCP rule matching any of the given server names
+func sniRule(hosts []string) string {
+	matchers := make([]string, len(hosts))
+	for i, host := range hosts {
+		matchers[i] = "HostSNI(`" + host + "`)"
+	}
+	return strings.Join(matchers, " || ")
+}
+
+// defaultRule returns the catch-all router rule of a protocol; UDP routers have no rule
+func defaultRule(protocol string) string {
+	switch protocol {
+	case ProtocolHTTP:
+		return "PathPrefix(`/`)"
+	case ProtocolUDP:
+		return ""
+	default:
+		return "HostSNI(`*`)"
+	}
+}
+
+// Group is a set of backends discovered together and rendered as one Traefik
+// router and service. Empty fields inherit the process-wide settings.
+type Group struct {
+	Name string `json:"name"`
+
+	// Discovery
+	DiscoveryMode     string   `json:"discoveryMode,omitempty"`
+	PodLabels         string   `json:"podLabels,omitempty"`
+	Namespaces        []string `json:"namespaces,omitempty"`
+	NamespaceSelector string   `json:"namespaceSelector,omitempty"`
+	DiscoveryService  string   `json:"discoveryService,omitempty"`
+	DiscoveryPortName string   `json:"discoveryPortName,omitempty"`
+	BackendPort       int      `json:"backendPort,omitempty"`
+	BackendPortName   string   `json:"backendPortName,omitempty"`
+
+	// Traefik routing
+	Protocol           string   `json:"protocol,omitempty"`
+	EntryPoints        []string `json:"entryPoints,omitempty"`
+	Rule               string   `json:"rule,omitempty"`
+	Priority           int      `json:"priority,omitempty"`
+	LoadBalancerMethod string   `json:"lbMethod,omitempty"`
+	RouterName         string   `json:"routerName,omitempty"`
+	ServiceName        string   `json:"serviceName,omitempty"`
+
+	// PassHostHeader forwards the client Host header to HTTP backends
+	PassHostHeader *bool `json:"passHostHeader,omitempty"`
+
+	// SNIHosts routes TLS connections by server name instead of a rule (TCP only)
+	SNIHosts []string `json:"sniHosts,omitempty"`
+	// TLS enables TLS on the router, passed through or terminated
+	TLS *GroupTLS `json:"tls,omitempty"`
+
+	// Canary splits traffic between the group's pods and a second selector
+	Canary *GroupCanary `json:"canary,omitempty"`
+}
+
+// GroupCanary selects canary pods that receive Weight percent of the group's
+// traffic. The selector follows the group's discovery mode.
+type GroupCanary struct {
+	PodLabels        string `json:"podLabels,omitempty"`
+	DiscoveryService string `json:"discoveryService,omitempty"`
+	Weight           int    `json:"weight"`
+}
+
+// StableServiceName is the child service holding the group's regular pods when a canary is set
+func (g *Group) StableServiceName() string {
+	return g.ServiceName + "-stable"
+}
+
+// CanaryServiceName is the child service holding the canary pods
+func (g *Group) CanaryServiceName() string {
+	return g.ServiceName + "-canary"
+}
+
+// GroupTLS is the TLS setup of a group's router. With Passthrough the
+// encrypted stream goes to the pods untouched; otherwise Traefik terminates
+// TLS with the referenced TLS options and certificate resolver, or the
+// certificates of its default store.
+type GroupTLS struct {
+	Passthrough  bool   `json:"passthrough,omitempty"`
+	Options      string `json:"options,omitempty"`
+	CertResolver string `json:"certResolver,omitempty"`
+}
+
+// groupsFile is the layout of the file pointed to by GROUPS_FILE
+type groupsFile struct {
+	Groups []Group `json:"groups"`
+}
+
+// DefaultGroup builds the single group described by the process-wide settings
+func (c *Config) DefaultGroup() Group {
+	namespaces := c.PodNamespaces
+	if len(namespaces) == 0 && c.PodNamespace != "" {
+		namespaces = []string{c.PodNamespace}
+	}
+	entryPoints := c.EntryPoints
+	if len(entryPoints) == 0 {
+		entryPoints = defaultEntryPoints(c.Protocol)
+	}
+	rule := c.RouterRule
+	if rule == "" && len(c.SNIHosts) > 0 {
+		rule = sniRule(c.SNIHosts)
+	}
+	if rule == "" {
+		rule = defaultRule(c.Protocol)
+	}
+	var tls *GroupTLS
+	if c.TLSPassthrough || c.TLSOptions != "" || c.TLSCertResolver != "" || len(c.SNIHosts) > 0 {
+		tls = &GroupTLS{
+			Passthrough:  c.TLSPassthrough,
+			Options:      c.TLSOptions,
+			CertResolver: c.TLSCertResolver,
+		}
+	}
+	var canary *GroupCanary
+	if c.CanaryPodLabels != "" || c.CanaryDiscoveryService != "" {
+		canary = &GroupCanary{
+			PodLabels:        c.CanaryPodLabels,
+			DiscoveryService: c.CanaryDiscoveryService,
+			Weight:           c.CanaryWeight,
+		}
+	}
+	return Group{
+		Name:               DefaultGroupName,
+		DiscoveryMode:      c.DiscoveryMode,
+		PodLabels:          c.PodLabels,
+		Namespaces:         namespaces,
+		NamespaceSelector:  c.NamespaceSelector,
+		DiscoveryService:   c.DiscoveryService,
+		DiscoveryPortName:  c.DiscoveryPortName,
+		BackendPort:        c.BackendPort,
+		BackendPortName:    c.BackendPortName,
+		Protocol:           c.Protocol,
+		EntryPoints:        entryPoints,
+		Rule:               rule,
+		Priority:           c.RouterPriority,
+		LoadBalancerMethod: c.LoadBalancerMethod,
+		RouterName:         c.RouterName,
+		ServiceName:        c.ServiceName,
+		PassHostHeader:     &c.PassHostHeader,
+		SNIHosts:           c.SNIHosts,
+		TLS:                tls,
+		Canary:             canary,
+	}
+}
+
+// BackendGroups returns the configured groups, or the default group when none are configured
+func (c *Config) BackendGroups() []Group {
+	if len(c.Groups) == 0 {
+		return []Group{c.DefaultGroup()}
+	}
+	return c.Groups
+}
+
+// loadGroupsFile reads backend groups from a YAML or JSON file and fills in defaults
+func (c *Config) loadGroupsFile(path string) ([]Group, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups file: %w", err)
+	}
+
+	var file groupsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse groups file: %w", err)
+	}
+	if len(file.Groups) == 0 {
+		return nil, fmt.Errorf("groups file %s defines no groups", path)
+	}
+
+	defaults := c.DefaultGroup()
+	for i := range file.Groups {
+		g := &file.Groups[i]
+		if g.DiscoveryMode == "" {
+			g.DiscoveryMode = defaults.DiscoveryMode
+		}
+		if len(g.Namespaces) == 0 {
+			g.Namespaces = defaults.Namespaces
+		}
+		if g.BackendPort == 0 {
+			g.BackendPort = defaults.BackendPort
+		}
+		// Entrypoints and rule defaults depend on the group's own protocol
+		if g.Protocol == "" {
+			g.Protocol = defaults.Protocol
+		}
+		if len(g.EntryPoints) == 0 {
+			if g.Protocol == defaults.Protocol {
+				g.EntryPoints = defaults.EntryPoints
+			} else {
+				g.EntryPoints = defaultEntryPoints(g.Protocol)
+			}
+		}
+		if g.Rule == "" && len(g.SNIHosts) > 0 {
+			g.Rule = sniRule(g.SNIHosts)
+		}
+		if g.Rule == "" {
+			// SNI routing isn't inherited, it needs the group's own TLS
+			if g.Protocol == defaults.Protocol && len(defaults.SNIHosts) == 0 {
+				g.Rule = defaults.Rule
+			} else {
+				g.Rule = defaultRule(g.Protocol)
+			}
+		}
+		if g.PassHostHeader == nil {
+			g.PassHostHeader = defaults.PassHostHeader
+		}
+		if g.LoadBalancerMethod == "" {
+			g.LoadBalancerMethod = defaults.LoadBalancerMethod
+		}
+		if g.RouterName == "" {
+			g.RouterName = g.Name + "-router"
+		}
+		if g.ServiceName == "" {
+			g.ServiceName = g.Name + "-service"
+		}
+	}
+	return file.Groups, nil
+}
+
+// validateGroups checks every group and that names don't collide
+func validateGroups(groups []Group) error {
+	names := make(map[string]bool)
+	routers := make(map[string]bool)
+	services := make(map[string]bool)
+	sniOwners := make(map[string]string) // entrypoint/host -> group
+	for i := range groups {
+		g := &groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("group %d: name is required", i)
+		}
+		if err := g.Validate(); err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		if names[g.Name] {
+			return fmt.Errorf("duplicate group name %s", g.Name)
+		}
+		if routers[g.RouterName] {
+			return fmt.Errorf("group %s: duplicate router name %s", g.Name, g.RouterName)
+		}
+		serviceNames := []string{g.ServiceName}
+		if g.Canary != nil {
+			serviceNames = append(serviceNames, g.StableServiceName(), g.CanaryServiceName())
+		}
+		for _, name := range serviceNames {
+			if services[name] {
+				return fmt.Errorf("group %s: duplicate service name %s", g.Name, name)
+			}
+			services[name] = true
+		}
+		for _, entryPoint := range g.EntryPoints {
+			for _, host := range g.SNIHosts {
+				key := entryPoint + "/" + strings.ToLower(host)
+				if owner, ok := sniOwners[key]; ok {
+					return fmt.Errorf("group %s: SNI host %s on entrypoint %s is already routed to group %s", g.Name, host, entryPoint, owner)
+				}
+				sniOwners[key] = g.Name
+			}
+		}
+		names[g.Name] = true
+		routers[g.RouterName] = true
+	}
+	return nil
+}
+
+// Validate checks if the group is valid
+func (g *Group) Validate() error {
+	switch g.DiscoveryMode {
+	case "", DiscoveryModePods:
+		if g.PodLabels == "" {
+			return fmt.Errorf("PodLabels is required")
+		}
+		if len(g.Namespaces) == 0 && g.NamespaceSelector == "" {
+			return fmt.Errorf("namespaces or NamespaceSelector is required")
+		}
+	case DiscoveryModeEndpointSlices:
+		if g.DiscoveryService == "" {
+			return fmt.Errorf("DiscoveryService is required in endpointslices mode")
+		}
+	default:
+		return fmt.Errorf("DiscoveryMode must be %q or %q", DiscoveryModePods, DiscoveryModeEndpointSlices)
+	}
+	if g.NamespaceSelector != "" {
+		if _, err := labels.Parse(g.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid NamespaceSelector: %w", err)
+		}
+	}
+	if g.BackendPort < 1 || g.BackendPort > 65535 {
+		return fmt.Errorf("BackendPort must be between 1 and 65535")
+	}
+	switch g.Protocol {
+	case "", ProtocolTCP, ProtocolHTTP:
+	case ProtocolUDP:
+		if g.Rule != "" {
+			return fmt.Errorf("UDP routers don't support a rule")
+		}
+	default:
+		return fmt.Errorf("Protocol must be one of %s, %s, %s", ProtocolTCP, ProtocolHTTP, ProtocolUDP)
+	}
+	if err := g.validateRouting(); err != nil {
+		return err
+	}
+	if err := g.validateTLS(); err != nil {
+		return err
+	}
+	return g.validateCanary()
+}
+
+// validateCanary checks that the canary selector fits the discovery mode
+func (g *Group) validateCanary() error {
+	if g.Canary == nil {
+		return nil
+	}
+	if g.Canary.Weight < 0 || g.Canary.Weight > 100 {
+		return fmt.Errorf("canary weight must be between 0 and 100")
+	}
+	if g.DiscoveryMode == DiscoveryModeEndpointSlices {
+		if g.Canary.DiscoveryService == "" || g.Canary.DiscoveryService == g.DiscoveryService {
+			return fmt.Errorf("canary needs its own DiscoveryService in endpointslices mode")
+		}
+		return nil
+	}
+	if g.Canary.PodLabels == "" || g.Canary.PodLabels == g.PodLabels {
+		return fmt.Errorf("canary needs its own PodLabels")
+	}
+	return nil
+}
+
+// validateRouting checks entrypoints, rule, priority and the Traefik object names
+func (g *Group) validateRouting() error {
+	if len(g.EntryPoints) == 0 {
+		return fmt.Errorf("at least one entry point is required")
+	}
+	for _, entryPoint := range g.EntryPoints {
+		if !validName(entryPoint) {
+			return fmt.Errorf("invalid entry point name %q", entryPoint)
+		}
+	}
+	for _, name := range []string{g.RouterName, g.ServiceName} {
+		if name != "" && !validName(name) {
+			return fmt.Errorf("invalid router or service name %q", name)
+		}
+	}
+	if g.Priority < 0 {
+		return fmt.Errorf("Priority must not be negative")
+	}
+	if g.Protocol == ProtocolUDP {
+		if g.Priority != 0 {
+			return fmt.Errorf("UDP routers don't support a priority")
+		}
+		return nil
+	}
+	return validateRule(g.Protocol, g.Rule)
+}
+
+// tcpMatchers are the rule matchers Traefik accepts on TCP routers
+var tcpMatchers = []string{"HostSNI(", "HostSNIRegexp(", "ClientIP(", "ALPN("}
+
+// validateRule does a syntactic check of a router rule. Traefik parses the rule
+// itself; this only catches the mistakes that would otherwise surface as a
+// rejected push: unbalanced quoting or parentheses, and HTTP matchers on TCP routers.
+func validateRule(protocol, rule string) error {
+	if rule == "" {
+		// Empty rules are filled with the protocol default before use
+		return nil
+	}
+	depth, quoted := 0, false
+	for _, r := range rule {
+		switch {
+		case r == '`':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("rule %q has unbalanced parentheses", rule)
+			}
+		}
+	}
+	if quoted {
+		return fmt.Errorf("rule %q has an unterminated backtick", rule)
+	}
+	if depth != 0 {
+		return fmt.Errorf("rule %q has unbalanced parentheses", rule)
+	}
+	if protocol == "" || protocol == ProtocolTCP {
+		for _, matcher := range tcpMatchers {
+			if strings.Contains(rule, matcher) {
+				return nil
+			}
+		}
+		return fmt.Errorf("rule %q has no TCP matcher (HostSNI, HostSNIRegexp, ClientIP or ALPN)", rule)
+	}
+	return nil
+}
+
+// validName reports whether name is usable as a Traefik entrypoint, router or
+// service name. "@" is reserved for provider qualification.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateTLS checks that SNI routing and TLS fit the group's protocol
+func (g *Group) validateTLS() error {
+	if len(g.SNIHosts) > 0 {
+		if g.Protocol != "" && g.Protocol != ProtocolTCP {
+			return fmt.Errorf("SNIHosts are only supported for TCP groups")
+		}
+		if g.TLS == nil {
+			return fmt.Errorf("SNIHosts require TLS")
+		}
+		if g.Rule != sniRule(g.SNIHosts) {
+			return fmt.Errorf("set either Rule or SNIHosts, not both")
+		}
+		for _, host := range g.SNIHosts {
+			if host == "" || host == "*" || strings.ContainsAny(host, "`, ") {
+				return fmt.Errorf("invalid SNI host %q", host)
+			}
+		}
+	}
+	if g.TLS == nil {
+		return nil
+	}
+	switch {
+	case g.Protocol == ProtocolUDP:
+		return fmt.Errorf("TLS is not supported for UDP groups")
+	case g.TLS.Passthrough && g.Protocol == ProtocolHTTP:
+		return fmt.Errorf("TLS passthrough is only supported for TCP groups")
+	case g.TLS.Passthrough && (g.TLS.Options != "" || g.TLS.CertResolver != ""):
+		return fmt.Errorf("TLS options and certResolver don't apply with passthrough")
+	}
+	return nil
+}
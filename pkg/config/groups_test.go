@@ -0,0 +1,274 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadGroupsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	data := []byte(`groups:
+- name: relay-a
+  podLabels: app=relay-a
+- name: relay-b
+  podLabels: app=relay-b
+  backendPort: 4444
+  entryPoints: [relay-b]
+  rule: HostSNI(` + "`b.example.com`" + `)
+  lbMethod: wrr
+  routerName: b-router
+- name: api
+  podLabels: app=api
+  protocol: http
+- name: telemetry
+  podLabels: app=telemetry
+  protocol: udp
+`)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Clearenv()
+	os.Setenv("TRAEFIK_API_URL", "http://localhost:8080/api")
+	os.Setenv("POD_NAMESPACE", "default")
+	os.Setenv("GROUPS_FILE", path)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if len(cfg.Groups) != 4 {
+		t.Fatalf("expected 4 groups, got %d", len(cfg.Groups))
+	}
+
+	a := cfg.Groups[0]
+	if a.RouterName != "relay-a-router" || a.ServiceName != "relay-a-service" {
+		t.Errorf("default names = %s/%s", a.RouterName, a.ServiceName)
+	}
+	if a.BackendPort != 3333 || a.LoadBalancerMethod != "leastconn" || a.EntryPoints[0] != "tcp" {
+		t.Errorf("defaults not applied: %+v", a)
+	}
+	if len(a.Namespaces) != 1 || a.Namespaces[0] != "default" {
+		t.Errorf("Namespaces = %v, want [default]", a.Namespaces)
+	}
+
+	b := cfg.Groups[1]
+	if b.BackendPort != 4444 || b.LoadBalancerMethod != "wrr" || b.RouterName != "b-router" || b.EntryPoints[0] != "relay-b" {
+		t.Errorf("overrides not kept: %+v", b)
+	}
+
+	// Entrypoints and rule defaults follow the group's protocol
+	api := cfg.Groups[2]
+	if api.EntryPoints[0] != "web" || api.Rule != "PathPrefix(`/`)" {
+		t.Errorf("http defaults not applied: %+v", api)
+	}
+	if api.PassHostHeader == nil || !*api.PassHostHeader {
+		t.Error("PassHostHeader should default to true")
+	}
+
+	udp := cfg.Groups[3]
+	if udp.EntryPoints[0] != "udp" || udp.Rule != "" {
+		t.Errorf("udp defaults not applied: %+v", udp)
+	}
+}
+
+func TestValidateGroups(t *testing.T) {
+	valid := func(name string) Group {
+		return Group{
+			Name:        name,
+			PodLabels:   "app=" + name,
+			Namespaces:  []string{"default"},
+			BackendPort: 3333,
+			EntryPoints: []string{"tcp"},
+			RouterName:  name + "-router",
+			ServiceName: name + "-service",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		groups  func() []Group
+		wantErr bool
+	}{
+		{"valid groups", func() []Group { return []Group{valid("a"), valid("b")} }, false},
+		{"duplicate name", func() []Group { return []Group{valid("a"), valid("a")} }, true},
+		{"duplicate service", func() []Group {
+			b := valid("b")
+			b.ServiceName = "a-service"
+			return []Group{valid("a"), b}
+		}, true},
+		{"invalid protocol", func() []Group {
+			a := valid("a")
+			a.Protocol = "sctp"
+			return []Group{a}
+		}, true},
+		{"udp group", func() []Group {
+			a := valid("a")
+			a.Protocol = ProtocolUDP
+			return []Group{a}
+		}, false},
+		{"udp group with rule", func() []Group {
+			a := valid("a")
+			a.Protocol = ProtocolUDP
+			a.Rule = "HostSNI(`*`)"
+			return []Group{a}
+		}, true},
+		{"sni groups sharing an entrypoint", func() []Group {
+			a, b := valid("a"), valid("b")
+			a.SNIHosts, a.Rule, a.TLS = []string{"a.example.com"}, "HostSNI(`a.example.com`)", &GroupTLS{Passthrough: true}
+			b.SNIHosts, b.Rule, b.TLS = []string{"b.example.com"}, "HostSNI(`b.example.com`)", &GroupTLS{Passthrough: true}
+			return []Group{a, b}
+		}, false},
+		{"sni host routed twice", func() []Group {
+			a, b := valid("a"), valid("b")
+			a.SNIHosts, a.Rule, a.TLS = []string{"a.example.com"}, "HostSNI(`a.example.com`)", &GroupTLS{Passthrough: true}
+			b.SNIHosts, b.Rule, b.TLS = []string{"A.example.com"}, "HostSNI(`A.example.com`)", &GroupTLS{}
+			return []Group{a, b}
+		}, true},
+		{"sni without tls", func() []Group {
+			a := valid("a")
+			a.SNIHosts, a.Rule = []string{"a.example.com"}, "HostSNI(`a.example.com`)"
+			return []Group{a}
+		}, true},
+		{"sni with a custom rule", func() []Group {
+			a := valid("a")
+			a.SNIHosts, a.Rule, a.TLS = []string{"a.example.com"}, "HostSNI(`*`)", &GroupTLS{}
+			return []Group{a}
+		}, true},
+		{"passthrough with options", func() []Group {
+			a := valid("a")
+			a.TLS = &GroupTLS{Passthrough: true, Options: "modern"}
+			return []Group{a}
+		}, true},
+		{"http passthrough", func() []Group {
+			a := valid("a")
+			a.Protocol = ProtocolHTTP
+			a.TLS = &GroupTLS{Passthrough: true}
+			return []Group{a}
+		}, true},
+		{"http termination", func() []Group {
+			a := valid("a")
+			a.Protocol = ProtocolHTTP
+			a.TLS = &GroupTLS{CertResolver: "le"}
+			return []Group{a}
+		}, false},
+		{"custom entrypoints and priority", func() []Group {
+			a := valid("a")
+			a.EntryPoints = []string{"relay-tcp", "relay-tcp-alt"}
+			a.Rule = "HostSNI(`*`) && ClientIP(`10.0.0.0/8`)"
+			a.Priority = 100
+			return []Group{a}
+		}, false},
+		{"invalid entrypoint name", func() []Group {
+			a := valid("a")
+			a.EntryPoints = []string{"relay tcp"}
+			return []Group{a}
+		}, true},
+		{"provider-qualified router name", func() []Group {
+			a := valid("a")
+			a.RouterName = "a-router@file"
+			return []Group{a}
+		}, true},
+		{"negative priority", func() []Group {
+			a := valid("a")
+			a.Priority = -1
+			return []Group{a}
+		}, true},
+		{"http matcher on tcp router", func() []Group {
+			a := valid("a")
+			a.Rule = "Host(`a.example.com`)"
+			return []Group{a}
+		}, true},
+		{"unbalanced rule", func() []Group {
+			a := valid("a")
+			a.Rule = "HostSNI(`a.example.com`"
+			return []Group{a}
+		}, true},
+		{"http rule on http router", func() []Group {
+			a := valid("a")
+			a.Protocol = ProtocolHTTP
+			a.Rule = "Host(`a.example.com`) && (PathPrefix(`/api`) || PathPrefix(`/v2`))"
+			return []Group{a}
+		}, false},
+		{"canary", func() []Group {
+			a := valid("a")
+			a.Canary = &GroupCanary{PodLabels: "app=a,track=canary", Weight: 10}
+			return []Group{a}
+		}, false},
+		{"canary sharing the stable labels", func() []Group {
+			a := valid("a")
+			a.Canary = &GroupCanary{PodLabels: "app=a", Weight: 10}
+			return []Group{a}
+		}, true},
+		{"canary weight over 100", func() []Group {
+			a := valid("a")
+			a.Canary = &GroupCanary{PodLabels: "app=a,track=canary", Weight: 120}
+			return []Group{a}
+		}, true},
+		{"canary service clashing with another group", func() []Group {
+			a, b := valid("a"), valid("b")
+			a.Canary = &GroupCanary{PodLabels: "app=a,track=canary"}
+			b.ServiceName = "a-service-canary"
+			return []Group{a, b}
+		}, true},
+		{"missing labels", func() []Group {
+			a := valid("a")
+			a.PodLabels = ""
+			return []Group{a}
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGroups(tt.groups())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateGroups() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadGroupsFileSNI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	data := []byte(`groups:
+- name: mqtt
+  podLabels: app=mqtt
+  sniHosts: [mqtt.example.com, mqtt.internal]
+  tls:
+    passthrough: true
+- name: relay
+  podLabels: app=relay
+  sniHosts: [relay.example.com]
+  tls:
+    options: modern
+    certResolver: le
+`)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Clearenv()
+	os.Setenv("TRAEFIK_API_URL", "http://localhost:8080/api")
+	os.Setenv("POD_NAMESPACE", "default")
+	os.Setenv("GROUPS_FILE", path)
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	want := "HostSNI(`mqtt.example.com`) || HostSNI(`mqtt.internal`)"
+	if got := cfg.Groups[0].Rule; got != want {
+		t.Errorf("Rule = %s, want %s", got, want)
+	}
+	if tls := cfg.Groups[1].TLS; tls == nil || tls.Options != "modern" || tls.CertResolver != "le" {
+		t.Errorf("TLS = %+v", tls)
+	}
+}
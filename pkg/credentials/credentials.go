@@ -0,0 +1,341 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Secret data keys, following the basic-auth and TLS Secret types
+const (
+	SecretKeyUsername = "username"
+	SecretKeyPassword = "password"
+	SecretKeyToken    = "token"
+	SecretKeyCA       = "ca.crt"
+	SecretKeyCert     = "tls.crt"
+	SecretKeyKey      = "tls.key"
+)
+
+// Material is the credential set used to talk to an API
+type Material struct {
+	Username string
+	Password string
+	Token    string // Bearer token, exclusive with basic auth
+	CA       []byte // PEM bundle trusted instead of the system roots
+	Cert     []byte // PEM client certificate for mTLS
+	Key      []byte // PEM client key for mTLS
+}
+
+// validate checks that the material is complete and unambiguous
+func (m *Material) validate() error {
+	if (m.Username == "") != (m.Password == "") {
+		return fmt.Errorf("username and password must be set together")
+	}
+	if m.Username != "" && m.Token != "" {
+		return fmt.Errorf("only one of basic auth and token may be set")
+	}
+	if (len(m.Cert) == 0) != (len(m.Key) == 0) {
+		return fmt.Errorf("client certificate and key must be set together")
+	}
+	return nil
+}
+
+// sameTLS reports whether both hold the same TLS material
+func (m *Material) sameTLS(other *Material) bool {
+	return bytes.Equal(m.CA, other.CA) && bytes.Equal(m.Cert, other.Cert) && bytes.Equal(m.Key, other.Key)
+}
+
+// tlsConfig builds the client TLS configuration, or nil to use the defaults
+func (m *Material) tlsConfig() (*tls.Config, error) {
+	if len(m.CA) == 0 && len(m.Cert) == 0 {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(m.CA) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(m.CA) {
+			return nil, fmt.Errorf("no certificates found in CA bundle")
+		}
+		cfg.RootCAs = pool
+	}
+	if len(m.Cert) > 0 {
+		cert, err := tls.X509KeyPair(m.Cert, m.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Source loads the current credentials
+type Source interface {
+	Load(ctx context.Context) (*Material, error)
+}
+
+// FileSource reads credentials from files, e.g. a mounted Secret. Empty
+// paths are skipped.
+type FileSource struct {
+	Username     string
+	PasswordFile string
+	TokenFile    string
+	CAFile       string
+	CertFile     string
+	KeyFile      string
+}
+
+// Load reads every configured file
+func (f *FileSource) Load(_ context.Context) (*Material, error) {
+	m := &Material{Username: f.Username}
+
+	password, err := readFile(f.PasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	token, err := readFile(f.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+	m.Password = strings.TrimSpace(string(password))
+	m.Token = strings.TrimSpace(string(token))
+
+	if m.CA, err = readFile(f.CAFile); err != nil {
+		return nil, err
+	}
+	if m.Cert, err = readFile(f.CertFile); err != nil {
+		return nil, err
+	}
+	if m.Key, err = readFile(f.KeyFile); err != nil {
+		return nil, err
+	}
+	return m, m.validate()
+}
+
+// readFile reads a credential file, returning nothing for an empty path
+func readFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential file: %w", err)
+	}
+	return data, nil
+}
+
+// SecretSource reads credentials from a Kubernetes Secret
+type SecretSource struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretSource creates a source backed by a Secret
+func NewSecretSource(client kubernetes.Interface, namespace, name string) *SecretSource {
+	return &SecretSource{client: client, namespace: namespace, name: name}
+}
+
+// Load fetches the Secret
+func (s *SecretSource) Load(ctx context.Context) (*Material, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials secret: %w", err)
+	}
+
+	m := &Material{
+		Username: strings.TrimSpace(string(secret.Data[SecretKeyUsername])),
+		Password: strings.TrimSpace(string(secret.Data[SecretKeyPassword])),
+		Token:    strings.TrimSpace(string(secret.Data[SecretKeyToken])),
+		CA:       secret.Data[SecretKeyCA],
+		Cert:     secret.Data[SecretKeyCert],
+		Key:      secret.Data[SecretKeyKey],
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return m, nil
+}
+
+// loadTimeout bounds a load of the source, independent of the request that triggered it
+const loadTimeout = 10 * time.Second
+
+// Bounds of the backoff between failed loads while no credentials were loaded yet
+const (
+	initialRetryDelay = time.Second
+	maxRetryDelay     = time.Minute
+)
+
+// Transport adds credentials to every request. The source is reloaded at
+// most once per refresh interval, so rotated credentials are picked up
+// without a restart; new TLS material replaces the underlying transport.
+// Reloads run in the background with their own timeout while requests keep
+// using the previous credentials, which also stay in use when a reload fails.
+type Transport struct {
+	source  Source
+	refresh time.Duration
+	newBase func(*tls.Config) *http.Transport
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	material *Material
+	base     *http.Transport
+	loading  chan struct{} // Closed when the running load finishes, nil while none runs
+	loadErr  error         // Error of the last load
+	failures int           // Consecutive failed loads
+}
+
+// NewTransport creates a transport using source. newBase builds the
+// underlying transport for a TLS configuration; refresh 0 loads only once.
+func NewTransport(source Source, refresh time.Duration, newBase func(*tls.Config) *http.Transport) *Transport {
+	return &Transport{source: source, refresh: refresh, newBase: newBase}
+}
+
+// RoundTrip sends the request with the current credentials
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	material, base, err := t.current(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	switch {
+	case material.Token != "":
+		req.Header.Set("Authorization", "Bearer "+material.Token)
+	case material.Username != "":
+		req.SetBasicAuth(material.Username, material.Password)
+	}
+	return base.RoundTrip(req)
+}
+
+// current returns the credentials and transport to use. A due reload is
+// started in the background; only the very first load is waited for.
+func (t *Transport) current(ctx context.Context) (*Material, *http.Transport, error) {
+	t.mu.Lock()
+	if t.material != nil {
+		if t.refresh > 0 && time.Since(t.loadedAt) >= t.refresh {
+			t.startLoad()
+		}
+		material, base := t.material, t.base
+		t.mu.Unlock()
+		return material, base, nil
+	}
+	// Without credentials a failing source is retried with backoff, not on every request
+	if t.loading == nil && t.loadErr != nil && time.Since(t.loadedAt) < t.retryDelay() {
+		err := t.loadErr
+		t.mu.Unlock()
+		return nil, nil, fmt.Errorf("failed to load API credentials: %w", err)
+	}
+	done := t.startLoad()
+	t.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("failed to load API credentials: %w", ctx.Err())
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.material == nil {
+		return nil, nil, fmt.Errorf("failed to load API credentials: %w", t.loadErr)
+	}
+	return t.material, t.base, nil
+}
+
+// retryDelay is the wait after a failed load before loading again while no
+// credentials were loaded yet. It doubles per failure up to the refresh
+// interval, or maxRetryDelay when not refreshing. t.mu must be held.
+func (t *Transport) retryDelay() time.Duration {
+	limit := t.refresh
+	if limit <= 0 {
+		limit = maxRetryDelay
+	}
+	d := initialRetryDelay
+	for i := 1; i < t.failures && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// startLoad starts loading the source unless a load is already running and
+// returns a channel closed once it finished. t.mu must be held.
+func (t *Transport) startLoad() <-chan struct{} {
+	if t.loading != nil {
+		return t.loading
+	}
+	done := make(chan struct{})
+	t.loading = done
+	previous := t.material
+	go func() {
+		defer close(done)
+		t.load(previous)
+	}()
+	return done
+}
+
+// load fetches the source and builds the transport for new TLS material
+// without holding the lock, then swaps both in
+func (t *Transport) load(previous *Material) {
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	material, err := t.source.Load(ctx)
+	var base *http.Transport
+	if err == nil && (previous == nil || !previous.sameTLS(material)) {
+		var tlsConfig *tls.Config
+		if tlsConfig, err = material.tlsConfig(); err == nil {
+			base = t.newBase(tlsConfig)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loading = nil
+	// Retry on the next interval rather than on every request
+	t.loadedAt = time.Now()
+	t.loadErr = err
+	if err != nil {
+		t.failures++
+		if t.material != nil {
+			slog.Warn("Failed to reload API credentials, keeping the previous ones", "error", err)
+		}
+		return
+	}
+	t.failures = 0
+
+	if t.material != nil {
+		if base != nil {
+			t.base.CloseIdleConnections()
+			slog.Info("Reloaded API TLS credentials")
+		}
+		if material.Password != t.material.Password || material.Token != t.material.Token || material.Username != t.material.Username {
+			slog.Info("Reloaded API auth credentials")
+		}
+	}
+	t.material = material
+	if base != nil {
+		t.base = base
+	}
+}
+
+// CloseIdleConnections closes idle connections of the underlying transport
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.base != nil {
+		t.base.CloseIdleConnections()
+	}
+}
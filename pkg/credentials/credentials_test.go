@@ -0,0 +1,378 @@
+package credentials
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newBase(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{TLSClientConfig: tlsConfig}
+}
+
+// staticSource returns whatever material or error it currently holds
+type staticSource struct {
+	mu       sync.Mutex
+	material *Material
+	err      error
+	loads    int
+	delay    time.Duration // Simulates a slow API server
+}
+
+func (s *staticSource) Load(ctx context.Context) (*Material, error) {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads++
+	return s.material, s.err
+}
+
+func (s *staticSource) setDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+func (s *staticSource) set(m *Material, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.material, s.err = m, err
+}
+
+// authServer records the Authorization header of every request
+func authServer(t *testing.T) (*httptest.Server, func() string) {
+	t.Helper()
+	var mu sync.Mutex
+	var last string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		last = r.Header.Get("Authorization")
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+}
+
+func get(t *testing.T, client *http.Client, url string) error {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTransportAuthHeaders(t *testing.T) {
+	srv, lastAuth := authServer(t)
+
+	tests := []struct {
+		name     string
+		material *Material
+		want     string
+	}{
+		{"basic auth", &Material{Username: "ilb", Password: "secret"}, "Basic aWxiOnNlY3JldA=="},
+		{"bearer token", &Material{Token: "abc"}, "Bearer abc"},
+		{"none", &Material{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := NewTransport(&staticSource{material: tt.material}, time.Minute, newBase)
+			if err := get(t, &http.Client{Transport: transport}, srv.URL); err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			if got := lastAuth(); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransportReloadsRotatedCredentials(t *testing.T) {
+	srv, lastAuth := authServer(t)
+	source := &staticSource{material: &Material{Token: "old"}}
+	transport := NewTransport(source, 20*time.Millisecond, newBase)
+	client := &http.Client{Transport: transport}
+
+	if err := get(t, client, srv.URL); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	source.set(&Material{Token: "new"}, nil)
+
+	// Cached until the refresh interval passes
+	if err := get(t, client, srv.URL); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if got := lastAuth(); got != "Bearer old" {
+		t.Errorf("Authorization before refresh = %q, want the cached token", got)
+	}
+
+	// The reload runs in the background, so the rotated token arrives shortly after
+	time.Sleep(30 * time.Millisecond)
+	if !eventually(func() bool {
+		return get(t, client, srv.URL) == nil && lastAuth() == "Bearer new"
+	}) {
+		t.Errorf("Authorization after refresh = %q, want the rotated token", lastAuth())
+	}
+
+	// A failing reload keeps the last good credentials
+	source.set(nil, errors.New("secret not found"))
+	time.Sleep(30 * time.Millisecond)
+	for range 3 {
+		if err := get(t, client, srv.URL); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if got := lastAuth(); got != "Bearer new" {
+			t.Errorf("Authorization after failed reload = %q, want the previous token", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTransportSlowReloadDoesNotBlock(t *testing.T) {
+	srv, lastAuth := authServer(t)
+	source := &staticSource{material: &Material{Token: "old"}}
+	transport := NewTransport(source, 10*time.Millisecond, newBase)
+	client := &http.Client{Transport: transport}
+
+	if err := get(t, client, srv.URL); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+
+	// A hanging API server must not hold back requests: they keep the old token
+	source.set(&Material{Token: "new"}, nil)
+	source.setDelay(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	for range 5 {
+		if err := get(t, client, srv.URL); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("requests took %s while the reload hung", elapsed)
+	}
+	if got := lastAuth(); got != "Bearer old" {
+		t.Errorf("Authorization during reload = %q, want the previous token", got)
+	}
+}
+
+func TestTransportInitialLoadOutlivesRequest(t *testing.T) {
+	srv, lastAuth := authServer(t)
+	source := &staticSource{material: &Material{Token: "abc"}, delay: 50 * time.Millisecond}
+	transport := NewTransport(source, time.Minute, newBase)
+
+	// A short request deadline fails the request, not the load
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, http.NoBody)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("request should fail at its deadline")
+	}
+
+	if err := get(t, &http.Client{Transport: transport}, srv.URL); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if got := lastAuth(); got != "Bearer abc" {
+		t.Errorf("Authorization = %q, want the loaded token", got)
+	}
+	if source.loads != 1 {
+		t.Errorf("loads = %d, want the interrupted request's load to be reused", source.loads)
+	}
+}
+
+// eventually polls cond for up to a second
+func eventually(cond func() bool) bool {
+	for range 100 {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestTransportInitialLoadFailure(t *testing.T) {
+	srv, _ := authServer(t)
+	transport := NewTransport(&staticSource{err: errors.New("secret not found")}, time.Minute, newBase)
+	if err := get(t, &http.Client{Transport: transport}, srv.URL); err == nil {
+		t.Error("request without loadable credentials should fail")
+	}
+}
+
+func TestTransportInitialLoadBackoff(t *testing.T) {
+	srv, lastAuth := authServer(t)
+	source := &staticSource{err: errors.New("secret not found")}
+	transport := NewTransport(source, 50*time.Millisecond, newBase)
+	client := &http.Client{Transport: transport}
+
+	// Requests within the retry delay get the last error without loading again
+	for range 5 {
+		if err := get(t, client, srv.URL); err == nil {
+			t.Fatal("request without loadable credentials should fail")
+		}
+	}
+	if loads := source.loads; loads != 1 {
+		t.Errorf("loads = %d, want 1 within the retry delay", loads)
+	}
+
+	source.set(&Material{Token: "abc"}, nil)
+	time.Sleep(60 * time.Millisecond)
+	if err := get(t, client, srv.URL); err != nil {
+		t.Fatalf("request after the retry delay failed: %v", err)
+	}
+	if got := lastAuth(); got != "Bearer abc" {
+		t.Errorf("Authorization = %q, want the loaded token", got)
+	}
+	if loads := source.loads; loads != 2 {
+		t.Errorf("loads = %d, want 2", loads)
+	}
+}
+
+func TestTransportMutualTLS(t *testing.T) {
+	certPEM, keyPEM := selfSigned(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	// Without the CA the server certificate isn't trusted
+	untrusted := NewTransport(&staticSource{material: &Material{Cert: certPEM, Key: keyPEM}}, 0, newBase)
+	if err := get(t, &http.Client{Transport: untrusted}, srv.URL); err == nil {
+		t.Error("request without the CA bundle should fail")
+	}
+
+	source := &staticSource{material: &Material{CA: caPEM, Cert: certPEM, Key: keyPEM}}
+	transport := NewTransport(source, 0, newBase)
+	resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("mTLS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+
+	// Refresh 0 loads once
+	if err := get(t, &http.Client{Transport: transport}, srv.URL); err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if source.loads != 1 {
+		t.Errorf("loads = %d, want 1", source.loads)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	source := &FileSource{Username: "ilb", PasswordFile: write("password", "secret\n")}
+	m, err := source.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if m.Username != "ilb" || m.Password != "secret" {
+		t.Errorf("Load() = %+v, want trimmed basic auth", m)
+	}
+
+	source = &FileSource{TokenFile: filepath.Join(dir, "missing")}
+	if _, err := source.Load(context.Background()); err == nil {
+		t.Error("Load() with a missing file should fail")
+	}
+
+	source = &FileSource{CertFile: write("tls.crt", "cert")}
+	if _, err := source.Load(context.Background()); err == nil {
+		t.Error("Load() with a certificate but no key should fail")
+	}
+}
+
+func TestSecretSource(t *testing.T) {
+	client := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "traefik-api", Namespace: "default"},
+		Data: map[string][]byte{
+			SecretKeyUsername: []byte("ilb"),
+			SecretKeyPassword: []byte("secret"),
+			SecretKeyCA:       []byte("ca"),
+		},
+	})
+
+	m, err := NewSecretSource(client, "default", "traefik-api").Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if m.Username != "ilb" || m.Password != "secret" || string(m.CA) != "ca" {
+		t.Errorf("Load() = %+v", m)
+	}
+
+	if _, err := NewSecretSource(client, "default", "missing").Load(context.Background()); err == nil {
+		t.Error("Load() of a missing secret should fail")
+	}
+}
+
+// selfSigned creates a PEM client certificate and key
+func selfSigned(t *testing.T) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ilb"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -0,0 +1,124 @@
+package discovery
+
+import (
+	"maps"
+	"sort"
+)
+
+// Backend is a single discovered server that can receive traffic
+type Backend struct {
+	// Group is the backend group the backend was discovered for
+	Group string `json:"group,omitempty"`
+	// Address is the ip:port the load balancer connects to
+	Address string `json:"address"`
+	// Weight is the relative share of connections, 0 means the load balancer default
+	Weight int `json:"weight,omitempty"`
+	// Canary marks backends discovered by the group's canary selector
+	Canary bool `json:"canary,omitempty"`
+
+	// Name and Namespace identify the pod behind the address
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Node is the node the pod runs on
+	Node string `json:"node,omitempty"`
+	// Zone is the topology zone of the node, only known when following EndpointSlices
+	Zone string `json:"zone,omitempty"`
+	// Labels are the pod labels, only known when selecting pods directly
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Unknown marks a placeholder standing in for a group whose backends
+	// aren't known yet; only Group is set. Load balancers keep the group's
+	// current configuration instead of rendering it without servers.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+// Placeholder returns the Unknown backend standing in for a group
+func Placeholder(group string) Backend {
+	return Backend{Group: group, Unknown: true}
+}
+
+// SplitUnknown separates placeholders from real backends and returns the
+// groups they stand in for
+func SplitUnknown(backends []Backend) (known []Backend, unknown map[string]bool) {
+	known = make([]Backend, 0, len(backends))
+	for _, b := range backends {
+		if !b.Unknown {
+			known = append(known, b)
+			continue
+		}
+		if unknown == nil {
+			unknown = make(map[string]bool)
+		}
+		unknown[b.Group] = true
+	}
+	return known, unknown
+}
+
+// String returns a human readable identifier for logs
+func (b Backend) String() string {
+	if b.Unknown {
+		return b.Group + " (unknown)"
+	}
+	if b.Name == "" {
+		return b.Address
+	}
+	return b.Namespace + "/" + b.Name + "@" + b.Address
+}
+
+// Equal reports whether two backends are identical
+func (b Backend) Equal(other Backend) bool {
+	return b.Group == other.Group &&
+		b.Address == other.Address &&
+		b.Weight == other.Weight &&
+		b.Canary == other.Canary &&
+		b.Name == other.Name &&
+		b.Namespace == other.Namespace &&
+		b.Node == other.Node &&
+		b.Zone == other.Zone &&
+		b.Unknown == other.Unknown &&
+		maps.Equal(b.Labels, other.Labels)
+}
+
+// SortBackends sorts backends by group and address
+func SortBackends(backends []Backend) {
+	sort.Slice(backends, func(i, j int) bool {
+		if backends[i].Group != backends[j].Group {
+			return backends[i].Group < backends[j].Group
+		}
+		return backends[i].Address < backends[j].Address
+	})
+}
+
+// EqualBackends compares two backend slices (assumes both are sorted)
+func EqualBackends(a, b []Backend) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Diff returns the identifiers of backends added and removed between two sets, keyed by group and address
+func Diff(before, after []Backend) (added, removed []string) {
+	old := make(map[[2]string]Backend, len(before))
+	for _, b := range before {
+		old[[2]string{b.Group, b.Address}] = b
+	}
+	for _, b := range after {
+		key := [2]string{b.Group, b.Address}
+		if _, ok := old[key]; ok {
+			delete(old, key)
+			continue
+		}
+		added = append(added, b.String())
+	}
+	for _, b := range old {
+		removed = append(removed, b.String())
+	}
+	sort.Strings(removed)
+	return added, removed
+}
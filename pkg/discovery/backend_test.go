@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEqualBackends(t *testing.T) {
+	a := []Backend{{Address: "10.0.0.1:3333", Name: "relay-1", Labels: map[string]string{"app": "relay"}}}
+	b := []Backend{{Address: "10.0.0.1:3333", Name: "relay-1", Labels: map[string]string{"app": "relay"}}}
+	if !EqualBackends(a, b) {
+		t.Error("identical backends should be equal")
+	}
+
+	b[0].Labels = map[string]string{"app": "relay", "version": "v2"}
+	if EqualBackends(a, b) {
+		t.Error("backends with different labels should not be equal")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := []Backend{
+		{Address: "10.0.0.1:3333", Name: "relay-1", Namespace: "default"},
+		{Address: "10.0.0.2:3333", Name: "relay-2", Namespace: "default"},
+	}
+	after := []Backend{
+		{Address: "10.0.0.2:3333", Name: "relay-2", Namespace: "default"},
+		{Address: "10.0.0.3:3333"},
+	}
+
+	added, removed := Diff(before, after)
+	if fmt.Sprint(added) != "[10.0.0.3:3333]" {
+		t.Errorf("added = %v", added)
+	}
+	if fmt.Sprint(removed) != "[default/relay-1@10.0.0.1:3333]" {
+		t.Errorf("removed = %v", removed)
+	}
+}
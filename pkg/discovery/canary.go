@@ -0,0 +1,54 @@
+package discovery
+
+import "context"
+
+// Source keys of the two halves of a canary split
+const (
+	stableKey = "stable"
+	canaryKey = "canary"
+)
+
+// CanarySplit merges the stable and canary sources of one group. Backends of
+// the canary source are marked Canary; the group tag is left to the caller.
+type CanarySplit struct {
+	multi        *Multi
+	backendsChan chan []Backend
+}
+
+// NewCanarySplit combines a stable and a canary source into one
+func NewCanarySplit(stable, canary Source) *CanarySplit {
+	return &CanarySplit{
+		multi:        NewMulti(map[string]Source{stableKey: stable, canaryKey: canary}),
+		backendsChan: make(chan []Backend, 1),
+	}
+}
+
+// Watch starts both sources. Like Multi, nothing is published until both
+// have reported once or the initial sync timed out. A canary source missing
+// then is left out, so the stable backends take all traffic until it reports.
+// A missing stable source keeps the whole group unknown rather than sending
+// everything to the canary.
+func (c *CanarySplit) Watch(ctx context.Context) (backends <-chan []Backend, errors <-chan error) {
+	in, errs := c.multi.Watch(ctx)
+	go func() {
+		defer close(c.backendsChan)
+		for merged := range in {
+			if known, unknown := SplitUnknown(merged); len(unknown) == 1 && unknown[canaryKey] && len(known) > 0 {
+				merged = known
+			}
+			out := make([]Backend, len(merged))
+			for i, b := range merged {
+				b.Canary = b.Group == canaryKey
+				b.Group = ""
+				out[i] = b
+			}
+			SendLatest(c.backendsChan, out)
+		}
+	}()
+	return c.backendsChan, errs
+}
+
+// Close stops both sources
+func (c *CanarySplit) Close() error {
+	return c.multi.Close()
+}
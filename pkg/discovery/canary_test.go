@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCanarySplitMarksCanaryBackends(t *testing.T) {
+	stable, canary := newFakeSource(), newFakeSource()
+	split := NewCanarySplit(stable, canary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := split.Watch(ctx)
+
+	stable.backends <- []Backend{{Address: "10.0.0.1:3333"}, {Address: "10.0.0.2:3333"}}
+	canary.backends <- []Backend{{Address: "10.0.1.1:3333"}}
+
+	select {
+	case got := <-backends:
+		if len(got) != 3 {
+			t.Fatalf("expected 3 backends, got %v", got)
+		}
+		for _, b := range got {
+			if b.Group != "" {
+				t.Errorf("backend %s keeps internal group tag %q", b.Address, b.Group)
+			}
+			if want := b.Address == "10.0.1.1:3333"; b.Canary != want {
+				t.Errorf("backend %s Canary = %v, want %v", b.Address, b.Canary, want)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for backends")
+	}
+}
+
+func TestCanarySplitWithoutCanaryReport(t *testing.T) {
+	stable, canary := newFakeSource(), newFakeSource()
+	split := NewCanarySplit(stable, canary)
+	split.multi.syncTimeout = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := split.Watch(ctx)
+
+	// The canary source never reports; the stable backends are still published
+	stable.backends <- []Backend{{Address: "10.0.0.1:3333"}}
+
+	select {
+	case got := <-backends:
+		if len(got) != 1 || got[0].Address != "10.0.0.1:3333" || got[0].Unknown || got[0].Canary {
+			t.Errorf("backends = %+v, want only the stable backend", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for backends")
+	}
+}
+
+func TestCanarySplitWithoutStableReport(t *testing.T) {
+	stable, canary := newFakeSource(), newFakeSource()
+	split := NewCanarySplit(stable, canary)
+	split.multi.syncTimeout = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := split.Watch(ctx)
+
+	// Without stable backends the group stays unknown instead of going all canary
+	canary.backends <- []Backend{{Address: "10.0.1.1:3333"}}
+
+	select {
+	case got := <-backends:
+		if _, unknown := SplitUnknown(got); len(unknown) == 0 {
+			t.Errorf("backends = %+v, want the group unknown", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for backends")
+	}
+}
@@ -0,0 +1,139 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Debouncer coalesces bursts of backend updates from a source. An update is
+// published once the source has been quiet for the settle window, or at the
+// latest maxDelay after the first update of a burst. Only the newest state
+// of a burst is published.
+type Debouncer struct {
+	source       Source
+	settle       time.Duration
+	maxDelay     time.Duration
+	backendsChan chan []Backend
+}
+
+// NewDebouncer wraps a source with a settle window and a maximum delay
+func NewDebouncer(source Source, settle, maxDelay time.Duration) *Debouncer {
+	if maxDelay < settle {
+		maxDelay = settle
+	}
+	return &Debouncer{
+		source:       source,
+		settle:       settle,
+		maxDelay:     maxDelay,
+		backendsChan: make(chan []Backend, 1),
+	}
+}
+
+// Watch starts the source and debounces its updates. Errors are passed through unchanged.
+func (d *Debouncer) Watch(ctx context.Context) (backends <-chan []Backend, errors <-chan error) {
+	in, errs := d.source.Watch(ctx)
+	if d.settle <= 0 {
+		return in, errs
+	}
+	go d.run(in)
+	return d.backendsChan, errs
+}
+
+// run collects updates and flushes the latest one when a timer fires
+func (d *Debouncer) run(in <-chan []Backend) {
+	defer close(d.backendsChan)
+
+	var (
+		pending    []Backend
+		hasPending bool
+		first      = true
+		burstSize  int
+		settleC    <-chan time.Time
+		maxC       <-chan time.Time
+		settle     *time.Timer
+		deadline   *time.Timer
+	)
+
+	flush := func(reason string) {
+		if settle != nil {
+			settle.Stop()
+		}
+		if deadline != nil {
+			deadline.Stop()
+		}
+		settleC, maxC = nil, nil
+		if !hasPending {
+			return
+		}
+		slog.Debug("Publishing debounced backends",
+			"backend_count", len(pending),
+			"coalesced_updates", burstSize,
+			"reason", reason)
+		SendLatest(d.backendsChan, pending)
+		pending, hasPending, burstSize = nil, false, 0
+	}
+
+	for {
+		select {
+		case backends, ok := <-in:
+			if !ok {
+				flush("source closed")
+				return
+			}
+			pending, hasPending = backends, true
+			burstSize++
+
+			// The initial state goes out right away so startup isn't delayed
+			if first {
+				first = false
+				flush("initial")
+				continue
+			}
+
+			if settle == nil {
+				settle = time.NewTimer(d.settle)
+			} else {
+				settle.Stop()
+				settle.Reset(d.settle)
+			}
+			settleC = settle.C
+			if maxC == nil {
+				if deadline == nil {
+					deadline = time.NewTimer(d.maxDelay)
+				} else {
+					deadline.Reset(d.maxDelay)
+				}
+				maxC = deadline.C
+			}
+
+		case <-settleC:
+			flush("settled")
+
+		case <-maxC:
+			flush("max delay")
+		}
+	}
+}
+
+// Close stops the source
+func (d *Debouncer) Close() error {
+	return d.source.Close()
+}
+
+// SendLatest delivers backends to ch without blocking. When ch is full the
+// oldest queued states are dropped, so consumers always see the newest state.
+func SendLatest(ch chan []Backend, backends []Backend) {
+	for {
+		select {
+		case ch <- backends:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+			slog.Debug("Dropped stale backend state in favor of a newer one")
+		default:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDebouncerCoalescesBursts(t *testing.T) {
+	source := newFakeSource()
+	source.backends = make(chan []Backend, 10)
+	d := NewDebouncer(source, 50*time.Millisecond, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := d.Watch(ctx)
+
+	// The initial state is published immediately
+	source.backends <- []Backend{{Address: "10.0.0.1:3333"}}
+	select {
+	case got := <-backends:
+		if len(got) != 1 {
+			t.Fatalf("initial backends = %v", got)
+		}
+	case <-time.After(40 * time.Millisecond):
+		t.Fatal("initial state should not be delayed")
+	}
+
+	// A burst collapses into its newest state
+	for i := 2; i <= 5; i++ {
+		state := make([]Backend, i)
+		source.backends <- state
+	}
+	select {
+	case got := <-backends:
+		if len(got) != 5 {
+			t.Errorf("expected newest state with 5 backends, got %d", len(got))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for debounced backends")
+	}
+
+	select {
+	case got := <-backends:
+		t.Errorf("unexpected extra publish: %v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDebouncerMaxDelay(t *testing.T) {
+	source := newFakeSource()
+	d := NewDebouncer(source, 40*time.Millisecond, 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := d.Watch(ctx)
+
+	source.backends <- nil
+	<-backends
+
+	// Updates keep arriving faster than the settle window
+	start := time.Now()
+	stop := time.After(300 * time.Millisecond)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			source.backends <- []Backend{{Address: time.Now().String()}}
+		case <-backends:
+			if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+				t.Errorf("publish took %v, expected max delay to bound it", elapsed)
+			}
+			return
+		case <-stop:
+			t.Fatal("max delay did not force a publish")
+		}
+	}
+}
+
+func TestSendLatestKeepsNewest(t *testing.T) {
+	ch := make(chan []Backend, 1)
+	SendLatest(ch, []Backend{{Address: "old"}})
+	SendLatest(ch, []Backend{{Address: "new"}})
+
+	got := <-ch
+	if got[0].Address != "new" {
+		t.Errorf("expected newest state, got %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+// TerminatingPolicy controls what happens to backends that are shutting down
+type TerminatingPolicy string
+
+const (
+	// TerminatingExclude drops a backend as soon as it starts terminating
+	TerminatingExclude TerminatingPolicy = "exclude"
+	// TerminatingUntilUnready keeps a terminating backend until it reports not ready
+	TerminatingUntilUnready TerminatingPolicy = "until-unready"
+	// TerminatingInclude treats terminating backends like any other backend
+	TerminatingInclude TerminatingPolicy = "include"
+)
+
+// ParseTerminatingPolicy validates a terminating policy name
+func ParseTerminatingPolicy(s string) (TerminatingPolicy, error) {
+	switch p := TerminatingPolicy(s); p {
+	case TerminatingExclude, TerminatingUntilUnready, TerminatingInclude:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown terminating policy %q: must be %q, %q or %q",
+			s, TerminatingExclude, TerminatingUntilUnready, TerminatingInclude)
+	}
+}
+
+// Filter decides which discovered pods and endpoints may receive traffic
+type Filter struct {
+	// RequireReady only admits backends whose Ready condition and readiness gates are true
+	RequireReady bool
+	// Terminating decides what to do with backends that are shutting down
+	Terminating TerminatingPolicy
+}
+
+// DefaultFilter only admits ready backends and drops them as soon as they terminate
+func DefaultFilter() Filter {
+	return Filter{
+		RequireReady: true,
+		Terminating:  TerminatingExclude,
+	}
+}
+
+// AllowPod reports whether the pod should receive traffic
+func (f Filter) AllowPod(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+	return f.allow(PodReady(pod), pod.DeletionTimestamp != nil)
+}
+
+// AllowEndpoint reports whether the EndpointSlice endpoint should receive traffic
+func (f Filter) AllowEndpoint(conditions discoveryv1.EndpointConditions) bool {
+	// Per the EndpointSlice API, nil ready means ready, nil serving falls back to
+	// ready and nil terminating means not terminating. Ready is always false for
+	// terminating endpoints, so serving is the readiness signal to use here.
+	ready := conditions.Ready == nil || *conditions.Ready
+	serving := ready
+	if conditions.Serving != nil {
+		serving = *conditions.Serving
+	}
+	terminating := conditions.Terminating != nil && *conditions.Terminating
+	return f.allow(serving, terminating)
+}
+
+// allow applies the policy to the readiness and termination state of a backend
+func (f Filter) allow(ready, terminating bool) bool {
+	if terminating {
+		switch f.Terminating {
+		case TerminatingInclude:
+		case TerminatingUntilUnready:
+			return ready
+		default:
+			return false
+		}
+	}
+	return ready || !f.RequireReady
+}
+
+// PodReady reports whether the pod's Ready condition and all of its readiness gates are true
+func PodReady(pod *corev1.Pod) bool {
+	conditions := make(map[corev1.PodConditionType]corev1.ConditionStatus, len(pod.Status.Conditions))
+	for _, c := range pod.Status.Conditions {
+		conditions[c.Type] = c.Status
+	}
+	if conditions[corev1.PodReady] != corev1.ConditionTrue {
+		return false
+	}
+	// The kubelet already folds readiness gates into Ready, but a gate flipped by
+	// an external controller shows up here before the kubelet reacts.
+	for _, gate := range pod.Spec.ReadinessGates {
+		if conditions[gate.ConditionType] != corev1.ConditionTrue {
+			return false
+		}
+	}
+	return true
+}
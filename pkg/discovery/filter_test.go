@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod(ready, terminating bool, gates ...corev1.PodConditionType) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	pod := &corev1.Pod{
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "10.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+	for _, gate := range gates {
+		pod.Spec.ReadinessGates = append(pod.Spec.ReadinessGates, corev1.PodReadinessGate{ConditionType: gate})
+	}
+	if terminating {
+		now := metav1.Now()
+		pod.DeletionTimestamp = &now
+	}
+	return pod
+}
+
+func TestFilterAllowPod(t *testing.T) {
+	lenient := Filter{RequireReady: false, Terminating: TerminatingExclude}
+	untilUnready := Filter{RequireReady: true, Terminating: TerminatingUntilUnready}
+	include := Filter{RequireReady: false, Terminating: TerminatingInclude}
+
+	gated := testPod(true, false, "example.com/lb-registered")
+	gatedTrue := testPod(true, false, "example.com/lb-registered")
+	gatedTrue.Status.Conditions = append(gatedTrue.Status.Conditions,
+		corev1.PodCondition{Type: "example.com/lb-registered", Status: corev1.ConditionTrue})
+
+	pending := testPod(true, false)
+	pending.Status.Phase = corev1.PodPending
+
+	tests := []struct {
+		name   string
+		filter Filter
+		pod    *corev1.Pod
+		want   bool
+	}{
+		{"ready pod", DefaultFilter(), testPod(true, false), true},
+		{"unready pod", DefaultFilter(), testPod(false, false), false},
+		{"unready pod without readiness requirement", lenient, testPod(false, false), true},
+		{"pending pod", lenient, pending, false},
+		{"terminating pod excluded", DefaultFilter(), testPod(true, true), false},
+		{"terminating ready pod kept until unready", untilUnready, testPod(true, true), true},
+		{"terminating unready pod dropped", untilUnready, testPod(false, true), false},
+		{"terminating unready pod included", include, testPod(false, true), true},
+		{"readiness gate not reported", DefaultFilter(), gated, false},
+		{"readiness gate true", DefaultFilter(), gatedTrue, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.AllowPod(tt.pod); got != tt.want {
+				t.Errorf("AllowPod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterAllowEndpoint(t *testing.T) {
+	yes, no := true, false
+	untilUnready := Filter{RequireReady: true, Terminating: TerminatingUntilUnready}
+
+	tests := []struct {
+		name       string
+		filter     Filter
+		conditions discoveryv1.EndpointConditions
+		want       bool
+	}{
+		{"nil conditions are ready", DefaultFilter(), discoveryv1.EndpointConditions{}, true},
+		{"not ready", DefaultFilter(), discoveryv1.EndpointConditions{Ready: &no}, false},
+		{"terminating excluded", DefaultFilter(),
+			discoveryv1.EndpointConditions{Ready: &no, Serving: &yes, Terminating: &yes}, false},
+		{"terminating serving kept", untilUnready,
+			discoveryv1.EndpointConditions{Ready: &no, Serving: &yes, Terminating: &yes}, true},
+		{"terminating not serving dropped", untilUnready,
+			discoveryv1.EndpointConditions{Ready: &no, Serving: &no, Terminating: &yes}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.AllowEndpoint(tt.conditions); got != tt.want {
+				t.Errorf("AllowEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTerminatingPolicy(t *testing.T) {
+	if _, err := ParseTerminatingPolicy("until-unready"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := ParseTerminatingPolicy("drain"); err == nil {
+		t.Error("expected error for unknown policy")
+	}
+}
@@ -0,0 +1,164 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// initialSyncTimeout bounds how long Multi waits for every group to report
+// before it publishes a partial set
+const initialSyncTimeout = 30 * time.Second
+
+// Source is a watcher feeding the backends of a single group
+type Source interface {
+	Watch(ctx context.Context) (<-chan []Backend, <-chan error)
+	Close() error
+}
+
+// Multi merges the backends of several groups into a single stream.
+// Every backend is tagged with the group its source belongs to.
+type Multi struct {
+	sources      map[string]Source
+	syncTimeout  time.Duration
+	backendsChan chan []Backend
+	errorChan    chan error
+}
+
+// groupUpdate is the latest backend set reported by one group
+type groupUpdate struct {
+	group    string
+	backends []Backend
+}
+
+// NewMulti creates a watcher merging the given sources, keyed by group name
+func NewMulti(sources map[string]Source) *Multi {
+	return &Multi{
+		sources:      sources,
+		syncTimeout:  initialSyncTimeout,
+		backendsChan: make(chan []Backend, 10),
+		errorChan:    make(chan error, 10),
+	}
+}
+
+// Watch starts all sources and merges their updates
+func (m *Multi) Watch(ctx context.Context) (backends <-chan []Backend, errors <-chan error) {
+	updates := make(chan groupUpdate)
+	var wg sync.WaitGroup
+
+	for group, source := range m.sources {
+		sourceBackends, sourceErrors := source.Watch(ctx)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for b := range sourceBackends {
+				select {
+				case updates <- groupUpdate{group: group, backends: b}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for err := range sourceErrors {
+				select {
+				case m.errorChan <- fmt.Errorf("group %s: %w", group, err):
+				default:
+					slog.Warn("Error channel full, dropping error", "group", group, "error", err)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(updates)
+	}()
+
+	go m.merge(updates)
+	return m.backendsChan, m.errorChan
+}
+
+// merge keeps the latest backends per group and publishes their union.
+// Nothing is published until every group has reported once, or until
+// initialSyncTimeout expires. Groups still missing then are published as
+// placeholders, so a slow group keeps its current configuration instead of
+// being wiped at startup.
+func (m *Multi) merge(updates <-chan groupUpdate) {
+	defer close(m.backendsChan)
+	defer close(m.errorChan)
+
+	latest := make(map[string][]Backend, len(m.sources))
+	synced := false
+	syncTimer := time.NewTimer(m.syncTimeout)
+	defer syncTimer.Stop()
+
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			tagged := make([]Backend, len(update.backends))
+			for i, b := range update.backends {
+				b.Group = update.group
+				tagged[i] = b
+			}
+			latest[update.group] = tagged
+
+			if !synced && len(latest) < len(m.sources) {
+				continue
+			}
+			synced = true
+			m.publish(latest)
+
+		case <-syncTimer.C:
+			if synced {
+				continue
+			}
+			synced = true
+			var missing []string
+			for group := range m.sources {
+				if _, ok := latest[group]; !ok {
+					missing = append(missing, group)
+				}
+			}
+			sort.Strings(missing)
+			slog.Warn("Not all backend groups reported in time, keeping the current configuration of the missing ones",
+				"missing_groups", missing)
+			m.publish(latest)
+		}
+	}
+}
+
+// publish sends the union of all group backends, with a placeholder for
+// every group that hasn't reported yet
+func (m *Multi) publish(latest map[string][]Backend) {
+	var all []Backend
+	for group := range m.sources {
+		backends, ok := latest[group]
+		if !ok {
+			all = append(all, Placeholder(group))
+			continue
+		}
+		all = append(all, backends...)
+	}
+	SortBackends(all)
+
+	// Send to channel, replacing any stale state still queued
+	SendLatest(m.backendsChan, all)
+}
+
+// Close stops all sources
+func (m *Multi) Close() error {
+	var firstErr error
+	for group, source := range m.sources {
+		if err := source.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("group %s: %w", group, err)
+		}
+	}
+	return firstErr
+}
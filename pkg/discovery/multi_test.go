@@ -0,0 +1,115 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeSource is a Source fed by the test
+type fakeSource struct {
+	backends chan []Backend
+	errors   chan error
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		backends: make(chan []Backend, 1),
+		errors:   make(chan error, 1),
+	}
+}
+
+func (f *fakeSource) Watch(ctx context.Context) (backends <-chan []Backend, errors <-chan error) {
+	go func() {
+		<-ctx.Done()
+		close(f.backends)
+		close(f.errors)
+	}()
+	return f.backends, f.errors
+}
+
+func (f *fakeSource) Close() error {
+	return nil
+}
+
+func TestMultiMergesGroups(t *testing.T) {
+	a, b := newFakeSource(), newFakeSource()
+	m := NewMulti(map[string]Source{"a": a, "b": b})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := m.Watch(ctx)
+
+	a.backends <- []Backend{{Address: "10.0.0.1:3333"}}
+
+	// Nothing is published until every group has reported
+	select {
+	case got := <-backends:
+		t.Fatalf("unexpected publish before all groups reported: %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.backends <- []Backend{{Address: "10.0.0.2:4444"}}
+
+	select {
+	case got := <-backends:
+		if len(got) != 2 || got[0].Group != "a" || got[1].Group != "b" {
+			t.Errorf("merged backends = %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for merged backends")
+	}
+
+	// A later update of one group keeps the other group's backends
+	a.backends <- nil
+	select {
+	case got := <-backends:
+		if len(got) != 1 || got[0].Group != "b" {
+			t.Errorf("merged backends = %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for merged backends")
+	}
+
+	cancel()
+	for range backends {
+	}
+}
+
+func TestMultiSyncTimeoutPublishesPlaceholders(t *testing.T) {
+	a, b := newFakeSource(), newFakeSource()
+	m := NewMulti(map[string]Source{"a": a, "b": b})
+	m.syncTimeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := m.Watch(ctx)
+
+	a.backends <- []Backend{{Address: "10.0.0.1:3333"}}
+
+	// The silent group is published as a placeholder, not as an empty group
+	select {
+	case got := <-backends:
+		known, unknown := SplitUnknown(got)
+		if len(known) != 1 || known[0].Group != "a" || !unknown["b"] || len(unknown) != 1 {
+			t.Errorf("backends after sync timeout = %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for partial backends")
+	}
+
+	// Once the group reports, its placeholder is replaced
+	b.backends <- nil
+	select {
+	case got := <-backends:
+		if len(got) != 1 || got[0].Group != "a" || got[0].Unknown {
+			t.Errorf("merged backends = %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for merged backends")
+	}
+
+	cancel()
+	for range backends {
+	}
+}
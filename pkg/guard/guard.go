@@ -0,0 +1,232 @@
+package guard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// Metric names reported by the guard
+const (
+	MetricEngaged   = "guard_engaged_total"
+	MetricReleased  = "guard_released_total"
+	MetricOverrides = "guard_overrides_total"
+	MetricHeld      = "guard_held_groups"
+)
+
+// Policy decides when a shrinking backend set is too suspicious to push
+type Policy struct {
+	MinBackends    int           // Hold changes leaving a group with fewer backends, 0 disables
+	MaxDropPercent int           // Hold changes removing more than this share of a group at once, 0 disables
+	HoldTimeout    time.Duration // Accept a held change after this long, 0 holds until overridden
+}
+
+// Enabled reports whether the policy can hold anything
+func (p Policy) Enabled() bool {
+	return p.MinBackends > 0 || p.MaxDropPercent > 0
+}
+
+// violation explains why a group going from previous to current backends is
+// held, or returns "" when the change is allowed. Without a known previous
+// state only the minimum applies.
+func (p Policy) violation(previous, current int, known bool) string {
+	if p.MinBackends > 0 && current < p.MinBackends && (!known || current < previous) {
+		return fmt.Sprintf("%d backends is below the minimum of %d", current, p.MinBackends)
+	}
+	if known && p.MaxDropPercent > 0 && previous > 0 && (previous-current)*100 > p.MaxDropPercent*previous {
+		return fmt.Sprintf("dropping %d of %d backends exceeds %d%%", previous-current, previous, p.MaxDropPercent)
+	}
+	return ""
+}
+
+// Guard holds back backend changes that would remove too many backends at
+// once, e.g. after a mistyped selector or a partial API response. Held groups
+// keep their last accepted backends until the change is allowed, the hold
+// timeout expires or an operator overrides it; other groups are unaffected.
+type Guard struct {
+	source       discovery.Source
+	groups       []string
+	policy       Policy
+	backendsChan chan []discovery.Backend
+	overrideChan chan struct{}
+}
+
+// New wraps a source with the given policy. groups lists every group the
+// source reports, so a group that vanishes entirely is still noticed.
+func New(source discovery.Source, groups []string, policy Policy) *Guard {
+	return &Guard{
+		source:       source,
+		groups:       groups,
+		policy:       policy,
+		backendsChan: make(chan []discovery.Backend, 1),
+		overrideChan: make(chan struct{}, 1),
+	}
+}
+
+// Watch starts the source and guards its updates. Errors are passed through unchanged.
+func (g *Guard) Watch(ctx context.Context) (backends <-chan []discovery.Backend, errors <-chan error) {
+	in, errs := g.source.Watch(ctx)
+	if !g.policy.Enabled() {
+		return in, errs
+	}
+	go g.run(in)
+	return g.backendsChan, errs
+}
+
+// Override accepts the currently held change once
+func (g *Guard) Override() {
+	select {
+	case g.overrideChan <- struct{}{}:
+	default:
+	}
+}
+
+// OverrideHandler returns an HTTP handler that triggers Override on POST
+func (g *Guard) OverrideHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		g.Override()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"status": "override requested",
+		})
+	})
+}
+
+// Close stops the source
+func (g *Guard) Close() error {
+	return g.source.Close()
+}
+
+// run evaluates every update against the last accepted state
+func (g *Guard) run(in <-chan []discovery.Backend) {
+	defer close(g.backendsChan)
+
+	var (
+		accepted = make(map[string][]discovery.Backend, len(g.groups))
+		pending  []discovery.Backend
+		holding  bool
+		hold     *time.Timer
+		holdC    <-chan time.Time
+	)
+
+	release := func() {
+		if hold != nil {
+			hold.Stop()
+		}
+		holdC = nil
+		holding = false
+		metrics.Set(MetricHeld, 0)
+	}
+
+	// apply publishes backends, keeping the last accepted state of held
+	// groups. Held groups without an accepted state and groups not known yet
+	// are published as placeholders, so the load balancer keeps what it has,
+	// e.g. a restored snapshot.
+	apply := func(backends []discovery.Backend, force bool) {
+		known, unknown := discovery.SplitUnknown(backends)
+		byGroup := make(map[string][]discovery.Backend, len(g.groups))
+		for _, b := range known {
+			byGroup[b.Group] = append(byGroup[b.Group], b)
+		}
+
+		held := make(map[string]bool)
+		if !force {
+			for _, group := range g.groups {
+				if unknown[group] {
+					continue
+				}
+				previous, wasAccepted := accepted[group]
+				current := byGroup[group]
+				if reason := g.policy.violation(len(previous), len(current), wasAccepted); reason != "" {
+					held[group] = true
+					slog.Warn("Safety guard holding backend change",
+						"group", group,
+						"previous_count", len(previous),
+						"current_count", len(current),
+						"reason", reason,
+						"hold_timeout", g.policy.HoldTimeout)
+				}
+			}
+		}
+
+		if len(held) == 0 {
+			release()
+		} else {
+			pending = backends
+			if !holding {
+				holding = true
+				metrics.Inc(MetricEngaged)
+				if g.policy.HoldTimeout > 0 {
+					if hold == nil {
+						hold = time.NewTimer(g.policy.HoldTimeout)
+					} else {
+						hold.Reset(g.policy.HoldTimeout)
+					}
+					holdC = hold.C
+				}
+			}
+			metrics.Set(MetricHeld, int64(len(held)))
+		}
+
+		for group, groupBackends := range byGroup {
+			if !held[group] {
+				accepted[group] = groupBackends
+			}
+		}
+		for _, group := range g.groups {
+			if _, ok := byGroup[group]; !ok && !held[group] && !unknown[group] {
+				delete(accepted, group)
+			}
+		}
+
+		var out []discovery.Backend
+		for _, groupBackends := range accepted {
+			out = append(out, groupBackends...)
+		}
+		for _, group := range g.groups {
+			if _, ok := accepted[group]; !ok && (held[group] || unknown[group]) {
+				out = append(out, discovery.Placeholder(group))
+			}
+		}
+		discovery.SortBackends(out)
+		discovery.SendLatest(g.backendsChan, out)
+	}
+
+	for {
+		select {
+		case backends, ok := <-in:
+			if !ok {
+				return
+			}
+			apply(backends, false)
+
+		case <-holdC:
+			slog.Warn("Safety guard hold timeout expired, accepting held backend change",
+				"backend_count", len(pending))
+			metrics.Inc(MetricReleased)
+			apply(pending, true)
+
+		case <-g.overrideChan:
+			if !holding {
+				slog.Info("Safety guard override requested but nothing is held")
+				continue
+			}
+			slog.Warn("Safety guard overridden, accepting held backend change",
+				"backend_count", len(pending))
+			metrics.Inc(MetricOverrides)
+			apply(pending, true)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package guard
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+type fakeSource struct {
+	backends chan []discovery.Backend
+	errors   chan error
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		backends: make(chan []discovery.Backend),
+		errors:   make(chan error),
+	}
+}
+
+func (f *fakeSource) Watch(context.Context) (<-chan []discovery.Backend, <-chan error) {
+	return f.backends, f.errors
+}
+
+func (f *fakeSource) Close() error { return nil }
+
+// groupBackends returns n backends in group
+func groupBackends(group string, n int) []discovery.Backend {
+	backends := make([]discovery.Backend, n)
+	for i := range backends {
+		backends[i] = discovery.Backend{Group: group, Address: fmt.Sprintf("10.0.0.%d:3333", i+1)}
+	}
+	return backends
+}
+
+func receive(t *testing.T, ch <-chan []discovery.Backend) []discovery.Backend {
+	t.Helper()
+	select {
+	case got := <-ch:
+		return got
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for backends")
+		return nil
+	}
+}
+
+func expectNothing(t *testing.T, ch <-chan []discovery.Backend) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected publish: %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPolicyViolation(t *testing.T) {
+	policy := Policy{MinBackends: 2, MaxDropPercent: 50}
+	tests := []struct {
+		name              string
+		previous, current int
+		known             bool
+		held              bool
+	}{
+		{"initial above minimum", 0, 3, false, false},
+		{"initial below minimum", 0, 1, false, true},
+		{"growing towards minimum", 0, 1, true, false},
+		{"shrinking below minimum", 3, 1, true, true},
+		{"drop within limit", 10, 5, true, false},
+		{"drop over limit", 10, 4, true, true},
+		{"emptied", 4, 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := policy.violation(tt.previous, tt.current, tt.known)
+			if (reason != "") != tt.held {
+				t.Errorf("violation(%d, %d, %v) = %q, held = %v", tt.previous, tt.current, tt.known, reason, tt.held)
+			}
+		})
+	}
+}
+
+func TestGuardHoldsOnlyTheShrinkingGroup(t *testing.T) {
+	source := newFakeSource()
+	g := New(source, []string{"a", "b"}, Policy{MaxDropPercent: 50})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := g.Watch(ctx)
+
+	source.backends <- append(groupBackends("a", 4), groupBackends("b", 2)...)
+	if got := receive(t, backends); len(got) != 6 {
+		t.Fatalf("initial state = %d backends, want 6", len(got))
+	}
+
+	engaged := metrics.Get(MetricEngaged)
+
+	// Group a vanishes while group b grows: a keeps its old backends
+	source.backends <- groupBackends("b", 3)
+	got := receive(t, backends)
+	counts := map[string]int{}
+	for _, b := range got {
+		counts[b.Group]++
+	}
+	if counts["a"] != 4 || counts["b"] != 3 {
+		t.Errorf("counts = %v, want a=4 b=3", counts)
+	}
+	if metrics.Get(MetricEngaged) != engaged+1 {
+		t.Error("guard engagement was not counted")
+	}
+	if metrics.Get(MetricHeld) != 1 {
+		t.Errorf("held groups = %d, want 1", metrics.Get(MetricHeld))
+	}
+
+	// The override accepts the held change
+	g.Override()
+	got = receive(t, backends)
+	if len(got) != 3 {
+		t.Errorf("after override = %d backends, want 3", len(got))
+	}
+	if metrics.Get(MetricHeld) != 0 {
+		t.Error("held groups gauge was not cleared")
+	}
+}
+
+func TestGuardHoldTimeout(t *testing.T) {
+	source := newFakeSource()
+	g := New(source, []string{"a"}, Policy{MinBackends: 2, HoldTimeout: 100 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := g.Watch(ctx)
+
+	// An initial state below the minimum is published as a placeholder
+	source.backends <- groupBackends("a", 1)
+	got := receive(t, backends)
+	if len(got) != 1 || !got[0].Unknown {
+		t.Fatalf("held initial state = %v, want a placeholder", got)
+	}
+	expectNothing(t, backends)
+
+	got = receive(t, backends)
+	if len(got) != 1 {
+		t.Errorf("after hold timeout = %d backends, want 1", len(got))
+	}
+}
+
+func TestGuardHoldsInitialGroupsSeparately(t *testing.T) {
+	source := newFakeSource()
+	g := New(source, []string{"a", "b"}, Policy{MinBackends: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := g.Watch(ctx)
+
+	// Group a is held below the minimum while group b is published
+	source.backends <- append(groupBackends("a", 1), groupBackends("b", 3)...)
+	got := receive(t, backends)
+	known, unknown := discovery.SplitUnknown(got)
+	if len(known) != 3 || known[0].Group != "b" || !unknown["a"] {
+		t.Fatalf("published = %v, want group b and a placeholder for a", got)
+	}
+
+	// Once group a reaches the minimum it is published too
+	source.backends <- append(groupBackends("a", 2), groupBackends("b", 3)...)
+	if got := receive(t, backends); len(got) != 5 {
+		t.Errorf("published = %v, want both groups", got)
+	}
+}
+
+func TestGuardRecoversWithoutRelease(t *testing.T) {
+	source := newFakeSource()
+	g := New(source, []string{"a"}, Policy{MaxDropPercent: 50})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := g.Watch(ctx)
+
+	source.backends <- groupBackends("a", 4)
+	receive(t, backends)
+
+	source.backends <- groupBackends("a", 1)
+	if got := receive(t, backends); len(got) != 4 {
+		t.Fatalf("held state = %d backends, want 4", len(got))
+	}
+
+	// The list comes back: published normally and the hold ends
+	source.backends <- groupBackends("a", 3)
+	if got := receive(t, backends); len(got) != 3 {
+		t.Errorf("recovered state = %d backends, want 3", len(got))
+	}
+}
+
+func TestGuardPassesPlaceholders(t *testing.T) {
+	source := newFakeSource()
+	g := New(source, []string{"a", "b"}, Policy{MinBackends: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	backends, _ := g.Watch(ctx)
+
+	// A group not known yet is neither held nor counted
+	source.backends <- append(groupBackends("a", 2), discovery.Placeholder("b"))
+	got := receive(t, backends)
+	known, unknown := discovery.SplitUnknown(got)
+	if len(known) != 2 || !unknown["b"] {
+		t.Errorf("published = %v, want group a and a placeholder for b", got)
+	}
+}
+
+func TestGuardDisabledPassesThrough(t *testing.T) {
+	source := newFakeSource()
+	g := New(source, []string{"a"}, Policy{})
+	backends, _ := g.Watch(context.Background())
+	if backends != (<-chan []discovery.Backend)(source.backends) {
+		t.Error("disabled guard should return the source channel")
+	}
+}
+
+func TestOverrideHandler(t *testing.T) {
+	g := New(newFakeSource(), nil, Policy{MinBackends: 1})
+	handler := g.OverrideHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/guard/override", http.NoBody))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/guard/override", http.NoBody))
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("POST status = %d, want 202", rec.Code)
+	}
+	select {
+	case <-g.overrideChan:
+	default:
+		t.Error("override was not requested")
+	}
+}
@@ -0,0 +1,296 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/circuitbreaker"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// Update counters: Runtime API commands changing a server, and updates with
+// more backends than server-template slots. The gauge counts enabled servers
+// failing their health checks at the last drift check.
+const (
+	MetricServerChanges  = "haproxy_server_changes_total"
+	MetricSlotsExhausted = "haproxy_slots_exhausted_total"
+	MetricServersDown    = "haproxy_servers_down"
+)
+
+// maxWeight is the largest server weight HAProxy accepts
+const maxWeight = 256
+
+// Responses of `set server addr` that mean success
+var addrChanged = []string{"IP changed from", "no need to change"}
+
+// Backend manages HAProxy backend servers through the Runtime API. Every
+// group maps to the HAProxy backend named by its ServiceName, whose servers,
+// typically declared with server-template, are slots: discovered backends are
+// written into them with `set server addr` and `enable server`, and unused
+// slots are put into maintenance with `disable server`, so no reload is needed.
+type Backend struct {
+	mu             sync.RWMutex
+	desired        map[string][]string // Addresses per HAProxy backend from the last successful update
+	groups         []config.Group
+	runtime        *runtimeClient
+	circuitBreaker *circuitbreaker.CircuitBreaker
+}
+
+// command is a Runtime API command changing one slot
+type command struct {
+	line       string
+	okPrefixes []string
+}
+
+// New creates a new HAProxy backend manager talking to cfg.HAProxyRuntimeAPI
+func New(cfg *config.Config) *Backend {
+	return &Backend{
+		groups:  cfg.BackendGroups(),
+		runtime: newRuntimeClient(cfg.HAProxyRuntimeAPI),
+		circuitBreaker: circuitbreaker.New(
+			cfg.CBMaxRequests,
+			cfg.CBInterval,
+			cfg.CBTimeout,
+			cfg.CBConsecutiveFailures,
+		),
+	}
+}
+
+// UpdateBackends writes the backends into the slots of every group's HAProxy backend
+func (b *Backend) UpdateBackends(ctx context.Context, backends []discovery.Backend) error {
+	return b.circuitBreaker.Execute(func() error {
+		return b.updateBackendsInternal(ctx, backends)
+	})
+}
+
+// updateBackendsInternal performs the actual backend update
+func (b *Backend) updateBackendsInternal(ctx context.Context, backends []discovery.Backend) error {
+	known, unknown := discovery.SplitUnknown(backends)
+	byGroup := make(map[string][]discovery.Backend)
+	for _, backend := range known {
+		byGroup[backend.Group] = append(byGroup[backend.Group], backend)
+	}
+
+	b.mu.RLock()
+	previous := b.desired
+	b.mu.RUnlock()
+
+	desired := make(map[string][]string, len(b.groups))
+	changes := 0
+	for _, g := range b.groups {
+		// Slots of groups with unknown backends are left as they are
+		if unknown[g.Name] {
+			slog.Warn("Backends of group unknown, keeping its current HAProxy servers", "group", g.Name)
+			if addrs, ok := previous[g.ServiceName]; ok {
+				desired[g.ServiceName] = addrs
+			}
+			continue
+		}
+		n, err := b.syncBackend(ctx, g.ServiceName, byGroup[g.Name])
+		changes += n
+		if err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		desired[g.ServiceName] = addresses(byGroup[g.Name])
+	}
+
+	b.mu.Lock()
+	b.desired = desired
+	b.mu.Unlock()
+
+	if changes == 0 {
+		slog.Debug("HAProxy backends already up to date", "backend_count", len(backends))
+		return nil
+	}
+	slog.Info("Updated HAProxy backends",
+		"backend_count", len(backends),
+		"group_count", len(b.groups),
+		"server_changes", changes,
+		"circuit_breaker_state", b.circuitBreaker.State())
+	return nil
+}
+
+// syncBackend brings the slots of one HAProxy backend in line with the
+// discovered backends and returns the number of commands sent
+func (b *Backend) syncBackend(ctx context.Context, name string, backends []discovery.Backend) (int, error) {
+	slots, err := b.serverState(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	commands, overflow, err := planSlots(name, slots, backends)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, cmd := range commands {
+		if err := b.runtime.run(ctx, cmd.line, cmd.okPrefixes...); err != nil {
+			return i, err
+		}
+		metrics.Inc(MetricServerChanges)
+		slog.Debug("Changed HAProxy server", "command", cmd.line)
+	}
+
+	if overflow > 0 {
+		metrics.Inc(MetricSlotsExhausted)
+		return len(commands), fmt.Errorf("backend %s has only %d slots for %d backends", name, len(slots), len(slots)+overflow)
+	}
+	return len(commands), nil
+}
+
+// planSlots returns the commands moving the slots of backend name to the
+// discovered backends and how many backends found no free slot. Slots already
+// serving a discovered address keep it, new addresses go to disabled slots
+// first, and slots left over are disabled last so capacity never dips.
+func planSlots(name string, slots []slot, backends []discovery.Backend) ([]command, int, error) {
+	want := make(map[string]discovery.Backend, len(backends))
+	for _, backend := range backends {
+		if _, _, err := net.SplitHostPort(backend.Address); err != nil {
+			return nil, 0, fmt.Errorf("invalid backend address %q: %w", backend.Address, err)
+		}
+		want[backend.Address] = backend
+	}
+
+	var commands []command
+	used := make([]bool, len(slots))
+	placed := make(map[string]bool, len(want))
+	for i, s := range slots {
+		backend, ok := want[s.address()]
+		if !s.enabled() || !ok || placed[backend.Address] {
+			continue
+		}
+		used[i] = true
+		placed[backend.Address] = true
+		commands = append(commands, setWeight(name, s, backend)...)
+	}
+
+	// Disabled slots first, then enabled ones serving a stale address
+	var free []int
+	for _, enabled := range []bool{false, true} {
+		for i, s := range slots {
+			if !used[i] && s.usable() && s.enabled() == enabled {
+				free = append(free, i)
+			}
+		}
+	}
+
+	overflow := 0
+	for _, address := range addresses(backends) {
+		if placed[address] {
+			continue
+		}
+		if len(free) == 0 {
+			overflow++
+			continue
+		}
+		i := free[0]
+		free = free[1:]
+		used[i] = true
+		s, backend := slots[i], want[address]
+
+		// Setting the address also ends the maintenance of an unresolved slot
+		server := name + "/" + s.name
+		if s.address() != address || s.admin&adminResolvMaint != 0 {
+			host, port, _ := net.SplitHostPort(address)
+			commands = append(commands, command{
+				line:       fmt.Sprintf("set server %s addr %s port %s", server, host, port),
+				okPrefixes: addrChanged,
+			})
+		}
+		commands = append(commands, setWeight(name, s, backend)...)
+		if s.admin&(adminForcedMaint|adminConfigMaint) != 0 {
+			commands = append(commands, command{line: "enable server " + server})
+		}
+	}
+
+	for i, s := range slots {
+		if !used[i] && s.enabled() {
+			commands = append(commands, command{line: "disable server " + name + "/" + s.name})
+		}
+	}
+	return commands, overflow, nil
+}
+
+// setWeight returns the command giving a slot the backend's weight, if it
+// differs. Weight 0 restores the weight from the configuration file.
+func setWeight(name string, s slot, backend discovery.Backend) []command {
+	weight := min(backend.Weight, maxWeight)
+	if weight == 0 {
+		weight = s.iweight
+	}
+	if weight == s.weight {
+		return nil
+	}
+	return []command{{line: fmt.Sprintf("set server %s/%s weight %d", name, s.name, weight)}}
+}
+
+// addresses returns the sorted, distinct addresses of the backends
+func addresses(backends []discovery.Backend) []string {
+	result := make([]string, 0, len(backends))
+	for _, backend := range backends {
+		result = append(result, backend.Address)
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// CheckDrift compares the enabled servers of every HAProxy backend with the
+// last update, e.g. to catch a reload that reset the slots to haproxy.cfg
+func (b *Backend) CheckDrift(ctx context.Context) ([]string, error) {
+	b.mu.RLock()
+	desired := b.desired
+	b.mu.RUnlock()
+	if desired == nil {
+		return nil, nil
+	}
+
+	var drift []string
+	down := 0
+	for _, name := range slices.Sorted(maps.Keys(desired)) {
+		slots, err := b.serverState(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		var live []string
+		for _, s := range slots {
+			if s.enabled() {
+				live = append(live, s.address())
+			}
+			if s.down() {
+				down++
+			}
+		}
+		slices.Sort(live)
+		if want := desired[name]; !slices.Equal(live, want) {
+			drift = append(drift, fmt.Sprintf("backend %s servers are [%s], want [%s]",
+				name, strings.Join(live, " "), strings.Join(want, " ")))
+		}
+	}
+	// Failing health checks are the backends' business, not drift
+	metrics.Set(MetricServersDown, int64(down))
+	return drift, nil
+}
+
+// HealthCheck checks that the Runtime API answers
+func (b *Backend) HealthCheck(ctx context.Context) error {
+	response, err := b.runtime.exec(ctx, "show info")
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	if !strings.Contains(response, "Name:") {
+		return fmt.Errorf("health check returned %q", firstLine(response))
+	}
+	return nil
+}
+
+// CircuitBreakerStats returns circuit breaker statistics
+func (b *Backend) CircuitBreakerStats() map[string]interface{} {
+	return b.circuitBreaker.Stats()
+}
@@ -0,0 +1,440 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+const stateHeader = "# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight " +
+	"srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state " +
+	"bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord"
+
+// fakeServer is one server of a fakeHAProxy backend
+type fakeServer struct {
+	name   string
+	addr   string
+	port   int
+	weight int
+	admin  int
+	op     int
+}
+
+// fakeHAProxy answers Runtime API commands like HAProxy in non-interactive
+// mode: one command per connection
+type fakeHAProxy struct {
+	mu       sync.Mutex
+	backends map[string][]*fakeServer
+	commands []string // Commands changing a server, in order
+}
+
+// newFakeHAProxy serves a backend "relay-service" with n disabled slots on a
+// Unix socket or TCP listener and returns the Runtime API address
+func newFakeHAProxy(t *testing.T, network string, n int) (*fakeHAProxy, string) {
+	t.Helper()
+	f := &fakeHAProxy{backends: map[string][]*fakeServer{}}
+	f.reload(n)
+
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(t.TempDir(), "haproxy.sock")
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+// reload resets the backend to n disabled slots, like a reload of haproxy.cfg
+func (f *fakeHAProxy) reload(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	servers := make([]*fakeServer, n)
+	for i := range servers {
+		// server-template srv 1-n 0.0.0.0:3333 disabled
+		servers[i] = &fakeServer{name: "srv" + strconv.Itoa(i+1), addr: "0.0.0.0", port: 3333, weight: 1, admin: 0x05, op: 2}
+	}
+	f.backends["relay-service"] = servers
+}
+
+func (f *fakeHAProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	fmt.Fprint(conn, f.handle(strings.TrimSpace(line)))
+}
+
+func (f *fakeHAProxy) handle(line string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fields := strings.Fields(line)
+
+	switch {
+	case line == "show info":
+		return "Name: HAProxy\nVersion: 2.8.0\n"
+	case strings.HasPrefix(line, "show servers state "):
+		servers, ok := f.backends[fields[3]]
+		if !ok {
+			return "Can't find backend.\n"
+		}
+		var b strings.Builder
+		b.WriteString("1\n" + stateHeader + "\n")
+		for i, s := range servers {
+			fmt.Fprintf(&b, "3 %s %d %s %s %d %d %d 1 0 1 0 0 0 0 0 0 - %d -\n",
+				fields[3], i+1, s.name, s.addr, s.op, s.admin, s.weight, s.port)
+		}
+		return b.String()
+	}
+
+	if len(fields) < 3 {
+		return "Unknown command.\n"
+	}
+	backend, name, _ := strings.Cut(fields[2], "/")
+	var server *fakeServer
+	for _, s := range f.backends[backend] {
+		if s.name == name {
+			server = s
+		}
+	}
+	if server == nil {
+		return "No such server.\n"
+	}
+	f.commands = append(f.commands, line)
+
+	switch {
+	case fields[0] == "enable" && fields[1] == "server":
+		server.admin &^= adminForcedMaint | adminConfigMaint
+	case fields[0] == "disable" && fields[1] == "server":
+		server.admin |= adminForcedMaint
+	case len(fields) == 7 && fields[3] == "addr" && fields[5] == "port":
+		response := fmt.Sprintf("IP changed from '%s' to '%s', port changed from '%d' to '%s' by 'stats socket command'\n",
+			server.addr, fields[4], server.port, fields[6])
+		server.addr = fields[4]
+		server.port, _ = strconv.Atoi(fields[6])
+		server.admin &^= adminResolvMaint
+		return response
+	case len(fields) == 5 && fields[3] == "weight":
+		server.weight, _ = strconv.Atoi(fields[4])
+	default:
+		return "Unknown command.\n"
+	}
+	return ""
+}
+
+// enabled lists the addresses of the enabled servers
+func (f *fakeHAProxy) enabled() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	got := make(map[string]string)
+	for _, s := range f.backends["relay-service"] {
+		if s.admin&adminMaint == 0 {
+			got[s.name] = net.JoinHostPort(s.addr, strconv.Itoa(s.port))
+		}
+	}
+	return got
+}
+
+// takeCommands returns and forgets the commands received so far
+func (f *fakeHAProxy) takeCommands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commands := f.commands
+	f.commands = nil
+	return commands
+}
+
+func testConfig(runtimeAPI string) *config.Config {
+	return &config.Config{
+		LoadBalancer:          config.LoadBalancerHAProxy,
+		HAProxyRuntimeAPI:     runtimeAPI,
+		PodLabels:             "app=test",
+		PodNamespace:          "default",
+		BackendPort:           3333,
+		RouterName:            "relay-router",
+		ServiceName:           "relay-service",
+		CBMaxRequests:         5,
+		CBInterval:            time.Minute,
+		CBTimeout:             30 * time.Second,
+		CBConsecutiveFailures: 5,
+	}
+}
+
+func relay(addresses ...string) []discovery.Backend {
+	backends := make([]discovery.Backend, len(addresses))
+	for i, address := range addresses {
+		backends[i] = discovery.Backend{Group: config.DefaultGroupName, Address: address}
+	}
+	return backends
+}
+
+func TestUpdateBackends(t *testing.T) {
+	fake, address := newFakeHAProxy(t, "unix", 3)
+	b := New(testConfig("unix://" + address))
+	ctx := context.Background()
+
+	if err := b.UpdateBackends(ctx, relay("10.0.0.1:3333", "10.0.0.2:3333")); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	want := map[string]string{"srv1": "10.0.0.1:3333", "srv2": "10.0.0.2:3333"}
+	if got := fake.enabled(); !maps.Equal(got, want) {
+		t.Errorf("enabled servers = %v, want %v", got, want)
+	}
+	fake.takeCommands()
+
+	// 10.0.0.2 keeps its slot, 10.0.0.3 goes to the free slot, and the slot
+	// of 10.0.0.1 is disabled last
+	if err := b.UpdateBackends(ctx, relay("10.0.0.2:3333", "10.0.0.3:3333")); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	want = map[string]string{"srv2": "10.0.0.2:3333", "srv3": "10.0.0.3:3333"}
+	if got := fake.enabled(); !maps.Equal(got, want) {
+		t.Errorf("enabled servers = %v, want %v", got, want)
+	}
+	wantCommands := []string{
+		"set server relay-service/srv3 addr 10.0.0.3 port 3333",
+		"enable server relay-service/srv3",
+		"disable server relay-service/srv1",
+	}
+	if got := fake.takeCommands(); !slices.Equal(got, wantCommands) {
+		t.Errorf("commands = %q, want %q", got, wantCommands)
+	}
+
+	// Nothing to do for the same backends
+	if err := b.UpdateBackends(ctx, relay("10.0.0.3:3333", "10.0.0.2:3333")); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := fake.takeCommands(); len(got) != 0 {
+		t.Errorf("commands = %q, want none", got)
+	}
+}
+
+func TestUpdateBackendsWeightsAndSlots(t *testing.T) {
+	fake, address := newFakeHAProxy(t, "tcp", 1)
+	b := New(testConfig(address))
+	ctx := context.Background()
+	exhausted := metrics.Get(MetricSlotsExhausted)
+
+	backends := relay("10.0.0.1:3333", "10.0.0.2:3333")
+	backends[0].Weight = 5
+	err := b.UpdateBackends(ctx, backends)
+	if err == nil || !strings.Contains(err.Error(), "only 1 slots for 2 backends") {
+		t.Fatalf("UpdateBackends() error = %v, want the slots to run out", err)
+	}
+	if got := metrics.Get(MetricSlotsExhausted) - exhausted; got != 1 {
+		t.Errorf("slot exhaustion count = %d, want 1", got)
+	}
+	// The backends that fit are still served
+	if got := fake.enabled(); got["srv1"] != "10.0.0.1:3333" {
+		t.Errorf("enabled servers = %v, want 10.0.0.1 in srv1", got)
+	}
+	wantCommands := []string{
+		"set server relay-service/srv1 addr 10.0.0.1 port 3333",
+		"set server relay-service/srv1 weight 5",
+		"enable server relay-service/srv1",
+	}
+	if got := fake.takeCommands(); !slices.Equal(got, wantCommands) {
+		t.Errorf("commands = %q, want %q", got, wantCommands)
+	}
+
+	// Weight 0 restores the configured weight
+	if err := b.UpdateBackends(ctx, relay("10.0.0.1:3333")); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := fake.takeCommands(); !slices.Equal(got, []string{"set server relay-service/srv1 weight 1"}) {
+		t.Errorf("commands = %q, want the weight reset", got)
+	}
+}
+
+func TestUpdateBackendsUnknownGroup(t *testing.T) {
+	fake, address := newFakeHAProxy(t, "unix", 2)
+	b := New(testConfig(address))
+	ctx := context.Background()
+
+	if err := b.UpdateBackends(ctx, relay("10.0.0.1:3333")); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	fake.takeCommands()
+
+	// A placeholder leaves the slots alone instead of disabling them all
+	placeholder := []discovery.Backend{discovery.Placeholder(config.DefaultGroupName)}
+	if err := b.UpdateBackends(ctx, placeholder); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := fake.takeCommands(); len(got) != 0 {
+		t.Errorf("commands = %q, want none", got)
+	}
+	if drift, err := b.CheckDrift(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("CheckDrift() = %v, %v, want the previous servers still desired", drift, err)
+	}
+}
+
+func TestUpdateBackendsUnknownBackend(t *testing.T) {
+	_, address := newFakeHAProxy(t, "tcp", 1)
+	cfg := testConfig(address)
+	cfg.ServiceName = "missing"
+	err := New(cfg).UpdateBackends(context.Background(), relay("10.0.0.1:3333"))
+	if err == nil || !strings.Contains(err.Error(), "Can't find backend") {
+		t.Errorf("UpdateBackends() error = %v, want HAProxy's error", err)
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	fake, address := newFakeHAProxy(t, "unix", 2)
+	b := New(testConfig(address))
+	ctx := context.Background()
+
+	if drift, err := b.CheckDrift(ctx); err != nil || drift != nil {
+		t.Errorf("CheckDrift() before any update = %v, %v, want nothing", drift, err)
+	}
+	if err := b.UpdateBackends(ctx, relay("10.0.0.1:3333")); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if drift, err := b.CheckDrift(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("CheckDrift() = %v, %v, want no drift", drift, err)
+	}
+
+	// A server failing its health checks is counted, but isn't drift
+	fake.mu.Lock()
+	fake.backends["relay-service"][0].op = 0
+	fake.mu.Unlock()
+	if drift, err := b.CheckDrift(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("CheckDrift() = %v, %v, want no drift", drift, err)
+	}
+	if got := metrics.Get(MetricServersDown); got != 1 {
+		t.Errorf("servers down = %d, want 1", got)
+	}
+
+	// A reload resets the slots to haproxy.cfg
+	fake.reload(2)
+	drift, err := b.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 1 || !strings.Contains(drift[0], "want [10.0.0.1:3333]") {
+		t.Errorf("CheckDrift() = %v, want the lost server", drift)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	_, address := newFakeHAProxy(t, "tcp", 1)
+	if err := New(testConfig(address)).HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck() error = %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	if err := New(testConfig(missing)).HealthCheck(context.Background()); err == nil {
+		t.Error("HealthCheck() without a socket should fail")
+	}
+}
+
+func TestParseServerState(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		wantErr  bool
+	}{
+		{"unknown backend", "Can't find backend.", true},
+		{"missing header", "1\n3 relay 1 srv1 10.0.0.1 2 0 1 1", true},
+		{"truncated line", "1\n" + stateHeader + "\n3 relay 1 srv1", true},
+		{"no servers", "1\n" + stateHeader, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseServerState(tt.response); (err != nil) != tt.wantErr {
+				t.Errorf("parseServerState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// serverStateFixture is `show servers state` of a server-template backend
+// with slots in every kind of maintenance
+const serverStateFixture = "1\n" + stateHeader + `
+3 relay-service 1 srv1 10.0.0.1 2 0 1 1 120 6 3 4 6 0 0 0 - 3333 -
+3 relay-service 2 srv2 10.0.0.2 0 32 1 1 120 1 0 0 0 0 0 0 - 3333 -
+3 relay-service 3 srv3 0.0.0.0 0 5 1 1 120 1 0 0 0 0 0 0 - 3333 -
+3 relay-service 4 srv4 0.0.0.0 0 2 1 1 120 1 0 0 0 0 0 0 - 3333 -
+3 relay-service 5 srv5 0.0.0.0 0 64 1 1 120 1 0 0 0 0 0 0 - 3333 -
+3 relay-service 6 srv6 10.0.0.9 0 0 1 1 120 1 0 0 0 0 0 0 - 3333 -
+`
+
+func TestPlanSlotsMaintenance(t *testing.T) {
+	slots, err := parseServerState(serverStateFixture)
+	if err != nil {
+		t.Fatalf("parseServerState() error = %v", err)
+	}
+	var enabled, down []string
+	for _, s := range slots {
+		if s.enabled() {
+			enabled = append(enabled, s.name)
+		}
+		if s.down() {
+			down = append(down, s.name)
+		}
+	}
+	// srv2 is in resolution maintenance despite its address, srv6 is up but failing checks
+	if !slices.Equal(enabled, []string{"srv1", "srv6"}) || !slices.Equal(down, []string{"srv6"}) {
+		t.Errorf("enabled = %v, down = %v, want [srv1 srv6] and [srv6]", enabled, down)
+	}
+
+	commands, overflow, err := planSlots("relay-service", slots, relay("10.0.0.1:3333", "10.0.0.2:3333", "10.0.0.3:3333"))
+	if err != nil || overflow != 0 {
+		t.Fatalf("planSlots() overflow = %d, error = %v", overflow, err)
+	}
+	var lines []string
+	for _, cmd := range commands {
+		lines = append(lines, cmd.line)
+	}
+	// The address of srv2 is set again to end its maintenance; srv4 and srv5
+	// can't be taken out of maintenance through the Runtime API
+	want := []string{
+		"set server relay-service/srv2 addr 10.0.0.2 port 3333",
+		"set server relay-service/srv3 addr 10.0.0.3 port 3333",
+		"enable server relay-service/srv3",
+		"disable server relay-service/srv6",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("commands = %q, want %q", lines, want)
+	}
+}
+
+func TestNewRuntimeClient(t *testing.T) {
+	for addr, want := range map[string][2]string{
+		"/var/run/haproxy.sock":        {"unix", "/var/run/haproxy.sock"},
+		"unix:///var/run/haproxy.sock": {"unix", "/var/run/haproxy.sock"},
+		"tcp://127.0.0.1:9999":         {"tcp", "127.0.0.1:9999"},
+		"haproxy:9999":                 {"tcp", "haproxy:9999"},
+	} {
+		c := newRuntimeClient(addr)
+		if c.network != want[0] || c.address != want[1] {
+			t.Errorf("newRuntimeClient(%q) = %s %s, want %s %s", addr, c.network, c.address, want[0], want[1])
+		}
+	}
+}
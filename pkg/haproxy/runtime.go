@@ -0,0 +1,88 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultCommandTimeout bounds a single Runtime API command
+const defaultCommandTimeout = 5 * time.Second
+
+// runtimeClient sends commands to the HAProxy Runtime API. HAProxy answers a
+// connection in non-interactive mode with one response and closes it, so
+// every command uses a connection of its own.
+type runtimeClient struct {
+	network string
+	address string
+	timeout time.Duration
+	dialer  net.Dialer
+}
+
+// newRuntimeClient creates a client for a Unix socket path or a TCP host:port,
+// optionally prefixed with unix:// or tcp://
+func newRuntimeClient(addr string) *runtimeClient {
+	network, address := "tcp", addr
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "tcp://"):
+		address = strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "/"):
+		network = "unix"
+	}
+	return &runtimeClient{network: network, address: address, timeout: defaultCommandTimeout}
+}
+
+// exec runs one command and returns HAProxy's response
+func (c *runtimeClient) exec(ctx context.Context, command string) (string, error) {
+	conn, err := c.dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to HAProxy runtime API: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", fmt.Errorf("failed to set deadline: %w", err)
+	}
+
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		return "", fmt.Errorf("failed to send %q: %w", command, err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response to %q: %w", command, err)
+	}
+	return strings.TrimSpace(string(response)), nil
+}
+
+// run executes a command changing server state and fails unless HAProxy
+// answers with nothing or one of the given success prefixes
+func (c *runtimeClient) run(ctx context.Context, command string, okPrefixes ...string) error {
+	response, err := c.exec(ctx, command)
+	if err != nil {
+		return err
+	}
+	if response == "" {
+		return nil
+	}
+	for _, prefix := range okPrefixes {
+		if strings.HasPrefix(response, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %s", command, firstLine(response))
+}
+
+// firstLine returns the first line of a response
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
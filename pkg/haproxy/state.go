@@ -0,0 +1,123 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// stateFormat is the only `show servers state` format understood
+const stateFormat = "1"
+
+// srv_admin_state bits putting a server into maintenance
+const (
+	adminForcedMaint   = 0x01 // Set by `disable server`, cleared by `enable server`
+	adminInheritMaint  = 0x02 // Inherited from a tracked server
+	adminConfigMaint   = 0x04 // `disabled` in the configuration file, cleared by `enable server`
+	adminResolvMaint   = 0x20 // No usable address, e.g. an unresolved slot; cleared by `set server addr`
+	adminHostnameMaint = 0x40 // FQDN set at runtime and not resolved yet
+
+	adminMaint = adminForcedMaint | adminInheritMaint | adminConfigMaint | adminResolvMaint | adminHostnameMaint
+)
+
+// srv_op_state of a server whose health checks fail
+const opStopped = 0
+
+// slot is one server of an HAProxy backend, e.g. created by server-template
+type slot struct {
+	name    string
+	addr    string // IP, 0.0.0.0 or empty while unassigned
+	port    int
+	weight  int // Current weight
+	iweight int // Weight from the configuration file
+	admin   int // srv_admin_state
+	op      int // srv_op_state
+}
+
+// address returns the slot's ip:port
+func (s slot) address() string {
+	return net.JoinHostPort(s.addr, strconv.Itoa(s.port))
+}
+
+// enabled reports whether the slot is out of every kind of maintenance
+func (s slot) enabled() bool {
+	return s.admin&adminMaint == 0
+}
+
+// usable reports whether the Runtime API can take the slot out of
+// maintenance; inherited and hostname maintenance can't be cleared by address
+func (s slot) usable() bool {
+	return s.admin&(adminInheritMaint|adminHostnameMaint) == 0
+}
+
+// down reports whether an enabled slot fails its health checks
+func (s slot) down() bool {
+	return s.enabled() && s.op == opStopped
+}
+
+// serverState reads the slots of an HAProxy backend
+func (b *Backend) serverState(ctx context.Context, backend string) ([]slot, error) {
+	response, err := b.runtime.exec(ctx, "show servers state "+backend)
+	if err != nil {
+		return nil, err
+	}
+	slots, err := parseServerState(response)
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %w", backend, err)
+	}
+	return slots, nil
+}
+
+// parseServerState parses the output of `show servers state <backend>`. The
+// columns are looked up by the names of the header line, which differ
+// between HAProxy versions.
+func parseServerState(response string) ([]slot, error) {
+	lines := strings.Split(response, "\n")
+	if strings.TrimSpace(lines[0]) != stateFormat {
+		return nil, fmt.Errorf("unexpected server state: %s", firstLine(response))
+	}
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "# ") {
+		return nil, fmt.Errorf("server state without header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range strings.Fields(strings.TrimPrefix(lines[1], "# ")) {
+		columns[name] = i
+	}
+	for _, name := range []string{"srv_name", "srv_addr", "srv_port", "srv_op_state", "srv_admin_state", "srv_uweight", "srv_iweight"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("server state without %s column", name)
+		}
+	}
+
+	var slots []slot
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < len(columns) {
+			return nil, fmt.Errorf("truncated server state line %q", line)
+		}
+		values := make(map[string]int)
+		for _, name := range []string{"srv_port", "srv_op_state", "srv_admin_state", "srv_uweight", "srv_iweight"} {
+			v, err := strconv.Atoi(fields[columns[name]])
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s in %q", name, line)
+			}
+			values[name] = v
+		}
+		slots = append(slots, slot{
+			name:    fields[columns["srv_name"]],
+			addr:    fields[columns["srv_addr"]],
+			port:    values["srv_port"],
+			weight:  values["srv_uweight"],
+			iweight: values["srv_iweight"],
+			admin:   values["srv_admin_state"],
+			op:      values["srv_op_state"],
+		})
+	}
+	return slots, nil
+}
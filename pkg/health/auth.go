@@ -0,0 +1,45 @@
+package health
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ReadToken reads a bearer token from a file, e.g. a mounted Secret
+func ReadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// RequireToken protects an admin endpoint with the bearer token stored in
+// tokenFile. The file is read on every request, so a rotated Secret applies
+// without a restart; a missing or empty file rejects every request.
+func RequireToken(tokenFile string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := ReadToken(tokenFile)
+		if err != nil {
+			slog.Error("Admin endpoint unavailable", "path", r.URL.Path, "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			slog.Warn("Rejected unauthenticated admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,58 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	handler := RequireToken(tokenFile, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	status := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodPost, "/guard/override", http.NoBody)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Without a token file every request is refused
+	if got := status("Bearer secret"); got != http.StatusServiceUnavailable {
+		t.Errorf("status without token file = %d, want 503", got)
+	}
+
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusAccepted},
+	} {
+		if got := status(tt.authorization); got != tt.want {
+			t.Errorf("status with %q = %d, want %d", tt.authorization, got, tt.want)
+		}
+	}
+
+	// A rotated token applies to the next request
+	if err := os.WriteFile(tokenFile, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := status("Bearer secret"); got != http.StatusUnauthorized {
+		t.Errorf("status with old token = %d, want 401", got)
+	}
+	if got := status("Bearer rotated"); got != http.StatusAccepted {
+		t.Errorf("status with rotated token = %d, want 202", got)
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"time"
 
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/interfaces"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
 )
 
 // Server provides health and readiness endpoints
 type Server struct {
 	mu       sync.RWMutex
 	checkers []interfaces.HealthChecker
+	handlers map[string]http.Handler
 	server   *http.Server
 	port     int
 	ready    bool
@@ -26,6 +28,7 @@ func NewServer(port int) *Server {
 	return &Server{
 		port:     port,
 		checkers: make([]interfaces.HealthChecker, 0),
+		handlers: make(map[string]http.Handler),
 		ready:    false,
 	}
 }
@@ -37,6 +40,13 @@ func (s *Server) AddChecker(checker interfaces.HealthChecker) {
 	s.checkers = append(s.checkers, checker)
 }
 
+// Handle registers an additional handler, e.g. an admin endpoint. Must be called before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[pattern] = handler
+}
+
 // SetReady sets the readiness status
 func (s *Server) SetReady(ready bool) {
 	s.mu.Lock()
@@ -50,6 +60,11 @@ func (s *Server) Start(ctx context.Context) error {
 	mux.HandleFunc("/healthz", s.healthHandler)
 	mux.HandleFunc("/readyz", s.readyHandler)
 	mux.HandleFunc("/metrics", s.metricsHandler)
+	s.mu.RLock()
+	for pattern, handler := range s.handlers {
+		mux.Handle(pattern, handler)
+	}
+	s.mu.RUnlock()
 
 	s.server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
@@ -146,14 +161,20 @@ func (s *Server) metricsHandler(w http.ResponseWriter, _ *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	metrics := map[string]interface{}{
+	values := map[string]interface{}{
 		"ready":          ready,
 		"checkers_count": len(checkers),
 		"uptime_seconds": time.Now().Unix(), // simplified
 	}
+	for name, value := range metrics.Snapshot() {
+		values[name] = value
+	}
+	for name, labeled := range metrics.LabeledSnapshot() {
+		values[name] = labeled
+	}
 
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(metrics)
+	_ = json.NewEncoder(w).Encode(values)
 }
 
 // KubernetesHealthChecker checks Kubernetes API connectivity
@@ -201,3 +222,27 @@ func (t *TraefikHealthChecker) Check(ctx context.Context) error {
 func (t *TraefikHealthChecker) Name() string {
 	return "traefik_api"
 }
+
+// HAProxyHealthChecker checks HAProxy Runtime API connectivity
+type HAProxyHealthChecker struct {
+	backend interface {
+		HealthCheck(context.Context) error
+	}
+}
+
+// NewHAProxyHealthChecker creates a new HAProxy health checker
+func NewHAProxyHealthChecker(backend interface{ HealthCheck(context.Context) error }) *HAProxyHealthChecker {
+	return &HAProxyHealthChecker{
+		backend: backend,
+	}
+}
+
+// Check performs the health check
+func (h *HAProxyHealthChecker) Check(ctx context.Context) error {
+	return h.backend.HealthCheck(ctx)
+}
+
+// Name returns the name of the checker
+func (h *HAProxyHealthChecker) Name() string {
+	return "haproxy_runtime_api"
+}
@@ -2,12 +2,14 @@ package interfaces
 
 import (
 	"context"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
 )
 
 // PodWatcher watches for pod changes in Kubernetes
 type PodWatcher interface {
-	// Watch starts watching for pod changes and sends backend addresses to the channel
-	Watch(ctx context.Context) (<-chan []string, <-chan error)
+	// Watch starts watching for pod changes and sends the discovered backends to the channel
+	Watch(ctx context.Context) (<-chan []discovery.Backend, <-chan error)
 	// Close stops the watcher
 	Close() error
 }
@@ -15,11 +17,17 @@ type PodWatcher interface {
 // LoadBalancerBackend manages load balancer backend configuration
 type LoadBalancerBackend interface {
 	// UpdateBackends updates the backend servers
-	UpdateBackends(ctx context.Context, backends []string) error
+	UpdateBackends(ctx context.Context, backends []discovery.Backend) error
 	// HealthCheck checks if the backend is healthy
 	HealthCheck(ctx context.Context) error
 }
 
+// DriftDetector compares the applied configuration with the live state of the load balancer
+type DriftDetector interface {
+	// CheckDrift describes every difference between the desired and the live configuration
+	CheckDrift(ctx context.Context) ([]string, error)
+}
+
 // HealthChecker provides health check functionality
 type HealthChecker interface {
 	// Check returns true if the component is healthy
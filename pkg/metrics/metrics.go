@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"maps"
+	"sync"
+)
+
+// registry holds process-wide counters and gauges, exposed by the health server
+var registry = struct {
+	mu      sync.RWMutex
+	values  map[string]int64
+	labeled map[string]map[string]int64 // Gauges by name and label value
+}{values: make(map[string]int64), labeled: make(map[string]map[string]int64)}
+
+// Inc increments a counter by one
+func Inc(name string) {
+	Add(name, 1)
+}
+
+// Add adds delta to a counter
+func Add(name string, delta int64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.values[name] += delta
+}
+
+// Set sets a gauge to value
+func Set(name string, value int64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.values[name] = value
+}
+
+// Get returns the current value of a counter or gauge
+func Get(name string) int64 {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.values[name]
+}
+
+// Snapshot returns a copy of all values
+func Snapshot() map[string]int64 {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return maps.Clone(registry.values)
+}
+
+// SetLabeled sets a labelled gauge, e.g. one value per group, to value
+func SetLabeled(name, label string, value int64) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.labeled[name] == nil {
+		registry.labeled[name] = make(map[string]int64)
+	}
+	registry.labeled[name][label] = value
+}
+
+// GetLabeled returns the current value of a labelled gauge
+func GetLabeled(name, label string) int64 {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	return registry.labeled[name][label]
+}
+
+// LabeledSnapshot returns a copy of all labelled gauges, by name and label value
+func LabeledSnapshot() map[string]map[string]int64 {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	snap := make(map[string]map[string]int64, len(registry.labeled))
+	for name, values := range registry.labeled {
+		snap[name] = maps.Clone(values)
+	}
+	return snap
+}
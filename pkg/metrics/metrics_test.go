@@ -0,0 +1,46 @@
+package metrics
+
+import "testing"
+
+func TestCountersAndGauges(t *testing.T) {
+	Inc("test_total")
+	Add("test_total", 2)
+	Set("test_gauge", 7)
+	Set("test_gauge", 4)
+
+	if got := Get("test_total"); got != 3 {
+		t.Errorf("test_total = %d, want 3", got)
+	}
+
+	snap := Snapshot()
+	if snap["test_gauge"] != 4 {
+		t.Errorf("test_gauge = %d, want 4", snap["test_gauge"])
+	}
+
+	// The snapshot is a copy
+	snap["test_gauge"] = 100
+	if got := Get("test_gauge"); got != 4 {
+		t.Errorf("snapshot modified registry: test_gauge = %d", got)
+	}
+}
+
+func TestLabeledGauges(t *testing.T) {
+	SetLabeled("test_weight", "a", 10)
+	SetLabeled("test_weight", "b", 20)
+	SetLabeled("test_weight", "a", 30)
+
+	if got := GetLabeled("test_weight", "a"); got != 30 {
+		t.Errorf("test_weight{a} = %d, want 30", got)
+	}
+
+	snap := LabeledSnapshot()
+	if len(snap["test_weight"]) != 2 || snap["test_weight"]["b"] != 20 {
+		t.Errorf("snapshot = %v, want a and b", snap["test_weight"])
+	}
+
+	// The snapshot is a copy
+	snap["test_weight"]["b"] = 100
+	if got := GetLabeled("test_weight", "b"); got != 20 {
+		t.Errorf("snapshot modified registry: test_weight{b} = %d", got)
+	}
+}
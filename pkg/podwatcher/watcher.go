@@ -4,125 +4,276 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"sort"
+	"maps"
+	"net"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
-// Watcher watches for pod changes using Kubernetes watch API
+// resyncPeriod is how often the informer replays its cache to the event
+// handlers. Resyncs are served from the local cache and never hit the API server.
+const resyncPeriod = 5 * time.Minute
+
+// Watcher watches for pod changes using a shared informer backed by a local cache
 type Watcher struct {
 	mu             sync.RWMutex
-	lastBackends   []string
-	namespace      string
+	lastBackends   []discovery.Backend
+	published      bool
+	namespaces     []string
+	nsSelector     string
 	labelSelector  string
-	clientset      *kubernetes.Clientset
-	backendsChan   chan []string
+	clientset      kubernetes.Interface
+	backendsChan   chan []discovery.Backend
 	errorChan      chan error
 	stopChan       chan struct{}
+	stopOnce       sync.Once
 	updateInterval time.Duration
 	backendPort    int
+	portName       string
+	portAnnotation string
+	weightAnno     string
 	useWatch       bool
+	filter         discovery.Filter
+
+	warnMu sync.Mutex
+	warned map[annotationWarning]bool // Invalid annotations already logged
+}
+
+// annotationWarning identifies an invalid annotation value of one pod
+type annotationWarning struct {
+	uid        types.UID
+	annotation string
+	value      string
+}
+
+// Options configures a pod watcher
+type Options struct {
+	// Namespaces to discover pods in. Ignored when NamespaceSelector is set.
+	Namespaces []string
+	// NamespaceSelector discovers pods in every namespace matching this label selector
+	NamespaceSelector string
+
+	LabelSelector    string
+	BackendPort      int
+	PortName         string // Named container port to route to, overrides BackendPort
+	PortAnnotation   string // Pod annotation holding a port number or name, overrides both
+	WeightAnnotation string // Pod annotation holding the backend weight
+	UpdateInterval   time.Duration
+	UseWatch         bool // Use a shared informer instead of polling
+	Filter           discovery.Filter
 }
 
 // New creates a new pod watcher
-func New(clientset *kubernetes.Clientset, namespace, labelSelector string, backendPort int, updateInterval time.Duration, useWatch bool) *Watcher {
+func New(clientset kubernetes.Interface, opts Options) *Watcher {
 	return &Watcher{
 		clientset:      clientset,
-		namespace:      namespace,
-		labelSelector:  labelSelector,
-		backendPort:    backendPort,
-		updateInterval: updateInterval,
-		useWatch:       useWatch,
-		backendsChan:   make(chan []string, 10),
+		namespaces:     opts.Namespaces,
+		nsSelector:     opts.NamespaceSelector,
+		labelSelector:  opts.LabelSelector,
+		backendPort:    opts.BackendPort,
+		portName:       opts.PortName,
+		portAnnotation: opts.PortAnnotation,
+		weightAnno:     opts.WeightAnnotation,
+		updateInterval: opts.UpdateInterval,
+		useWatch:       opts.UseWatch,
+		filter:         opts.Filter,
+		backendsChan:   make(chan []discovery.Backend, 10),
 		errorChan:      make(chan error, 10),
 		stopChan:       make(chan struct{}),
+		warned:         make(map[annotationWarning]bool),
 	}
 }
 
 // Watch starts watching for pod changes
-func (w *Watcher) Watch(ctx context.Context) (backends <-chan []string, errors <-chan error) {
+func (w *Watcher) Watch(ctx context.Context) (backends <-chan []discovery.Backend, errors <-chan error) {
 	if w.useWatch {
-		go w.watchWithAPI(ctx)
+		go w.watchWithInformer(ctx)
 	} else {
 		go w.watchWithPolling(ctx)
 	}
 	return w.backendsChan, w.errorChan
 }
 
-// watchWithAPI uses Kubernetes watch API for real-time updates
-func (w *Watcher) watchWithAPI(ctx context.Context) {
-	slog.Info("Starting pod watcher with Kubernetes watch API",
-		"namespace", w.namespace,
+// watchWithInformer keeps a local pod cache in sync through shared informers
+// and recomputes backends from that cache. The informers' reflectors take care
+// of relisting on 410 Gone, watch bookmarks and periodic resyncs.
+func (w *Watcher) watchWithInformer(ctx context.Context) {
+	slog.Info("Starting pod watcher with shared informer",
+		"namespaces", w.namespaces,
+		"namespaceSelector", w.nsSelector,
 		"labelSelector", w.labelSelector)
 
-	for {
+	defer func() {
+		slog.Info("Pod watcher stopped")
+		close(w.backendsChan)
+		close(w.errorChan)
+	}()
+
+	stopCh := make(chan struct{})
+	go func() {
 		select {
 		case <-ctx.Done():
-			slog.Info("Pod watcher stopped")
-			close(w.backendsChan)
-			close(w.errorChan)
-			return
+		case <-w.stopChan:
+		}
+		close(stopCh)
+	}()
+
+	// Events only signal that the cache changed; a burst of events collapses
+	// into a single recomputation.
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
 		default:
-			if err := w.runWatch(ctx); err != nil {
-				slog.Error("Watch error, restarting", "error", err)
-				w.errorChan <- err
-				time.Sleep(5 * time.Second)
-			}
 		}
 	}
-}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { notify() },
+		UpdateFunc: func(_, _ any) { notify() },
+		DeleteFunc: func(any) { notify() },
+	}
 
-// runWatch runs a single watch session
-func (w *Watcher) runWatch(ctx context.Context) error {
-	watcher, err := w.clientset.CoreV1().Pods(w.namespace).Watch(ctx, metav1.ListOptions{
-		LabelSelector: w.labelSelector,
+	pc := &podCache{}
+	var factories []informers.SharedInformerFactory
+	var synced []cache.InformerSynced
+	register := func(kind string, informer cache.SharedIndexInformer) error {
+		if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			slog.Warn("Watch interrupted, informer will relist", "resource", kind, "error", err)
+			w.sendError(fmt.Errorf("%s watch error: %w", kind, err))
+		}); err != nil {
+			return fmt.Errorf("failed to set %s watch error handler: %w", kind, err)
+		}
+		if _, err := informer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("failed to register %s event handler: %w", kind, err)
+		}
+		synced = append(synced, informer.HasSynced)
+		return nil
+	}
+	podOptions := informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+		opts.LabelSelector = w.labelSelector
 	})
-	if err != nil {
-		return fmt.Errorf("failed to start watch: %w", err)
+
+	podNamespaces := w.namespaces
+	if w.nsSelector != "" {
+		// A single cluster-wide pod informer, filtered by the namespace cache
+		podNamespaces = []string{metav1.NamespaceAll}
+
+		nsFactory := informers.NewSharedInformerFactoryWithOptions(w.clientset, resyncPeriod,
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.LabelSelector = w.nsSelector
+			}),
+		)
+		nsInformer := nsFactory.Core().V1().Namespaces()
+		if err := register("namespace", nsInformer.Informer()); err != nil {
+			w.sendError(err)
+			return
+		}
+		pc.namespaces = nsInformer.Lister()
+		factories = append(factories, nsFactory)
 	}
-	defer watcher.Stop()
 
-	// Get initial list
-	if err := w.updateBackendList(ctx); err != nil {
-		return fmt.Errorf("failed to get initial pod list: %w", err)
+	for _, namespace := range podNamespaces {
+		factory := informers.NewSharedInformerFactoryWithOptions(w.clientset, resyncPeriod,
+			informers.WithNamespace(namespace), podOptions)
+		podInformer := factory.Core().V1().Pods()
+		if err := register("pod", podInformer.Informer()); err != nil {
+			w.sendError(err)
+			return
+		}
+		pc.pods = append(pc.pods, podInformer.Lister())
+		factories = append(factories, factory)
+	}
+
+	for _, factory := range factories {
+		factory.Start(stopCh)
+	}
+	defer func() {
+		for _, factory := range factories {
+			factory.Shutdown()
+		}
+	}()
+
+	if !cache.WaitForCacheSync(stopCh, synced...) {
+		return
+	}
+	slog.Info("Pod cache synced", "informers", len(synced))
+
+	// Initial update from the freshly synced cache
+	if err := w.updateFromCache(pc); err != nil {
+		slog.Error("Failed initial pod discovery", "error", err)
+		w.sendError(err)
 	}
 
-	// Watch for changes
 	for {
 		select {
-		case <-ctx.Done():
-			return nil
-		case event, ok := <-watcher.ResultChan():
-			if !ok {
-				return fmt.Errorf("watch channel closed")
+		case <-stopCh:
+			return
+		case <-changed:
+			if err := w.updateFromCache(pc); err != nil {
+				slog.Error("Failed to update backend list", "error", err)
+				w.sendError(err)
 			}
-			w.handleWatchEvent(ctx, event)
 		}
 	}
 }
 
-// handleWatchEvent processes a watch event
-func (w *Watcher) handleWatchEvent(ctx context.Context, event watch.Event) {
-	switch event.Type {
-	case watch.Added, watch.Modified, watch.Deleted:
-		if err := w.updateBackendList(ctx); err != nil {
-			slog.Error("Failed to update backend list", "error", err)
-			w.errorChan <- err
+// podCache reads pods from the informer caches
+type podCache struct {
+	// pods holds one lister per watched namespace, or a single cluster-wide lister
+	pods []listersv1.PodLister
+	// namespaces is set when namespaces are selected by label
+	namespaces listersv1.NamespaceLister
+}
+
+// list returns all cached pods in the watched namespaces
+func (c *podCache) list() ([]*corev1.Pod, error) {
+	var result []*corev1.Pod
+	for _, lister := range c.pods {
+		// The informers are already scoped to the namespace and label selector
+		pods, err := lister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods {
+			if c.namespaces != nil {
+				if _, err := c.namespaces.Get(pod.Namespace); err != nil {
+					continue
+				}
+			}
+			result = append(result, pod)
 		}
-	case watch.Error:
-		slog.Error("Received error event from watch")
 	}
+	return result, nil
+}
+
+// updateFromCache recomputes backends from the informer cache
+func (w *Watcher) updateFromCache(pc *podCache) error {
+	pods, err := pc.list()
+	if err != nil {
+		return fmt.Errorf("failed to list pods from cache: %w", err)
+	}
+
+	w.publish(w.extractBackends(pods))
+	return nil
 }
 
 // watchWithPolling uses traditional polling for pod discovery
 func (w *Watcher) watchWithPolling(ctx context.Context) {
 	slog.Info("Starting pod watcher with polling",
-		"namespace", w.namespace,
+		"namespaces", w.namespaces,
+		"namespaceSelector", w.nsSelector,
 		"labelSelector", w.labelSelector,
 		"interval", w.updateInterval)
 
@@ -132,7 +283,7 @@ func (w *Watcher) watchWithPolling(ctx context.Context) {
 	// Initial update
 	if err := w.updateBackendList(ctx); err != nil {
 		slog.Error("Failed initial pod discovery", "error", err)
-		w.errorChan <- err
+		w.sendError(err)
 	}
 
 	for {
@@ -142,10 +293,15 @@ func (w *Watcher) watchWithPolling(ctx context.Context) {
 			close(w.backendsChan)
 			close(w.errorChan)
 			return
+		case <-w.stopChan:
+			slog.Info("Pod watcher stopped")
+			close(w.backendsChan)
+			close(w.errorChan)
+			return
 		case <-ticker.C:
 			if err := w.updateBackendList(ctx); err != nil {
 				slog.Error("Failed to update backend list", "error", err)
-				w.errorChan <- err
+				w.sendError(err)
 			}
 		}
 	}
@@ -153,69 +309,190 @@ func (w *Watcher) watchWithPolling(ctx context.Context) {
 
 // updateBackendList fetches the current pod list and updates backends if changed
 func (w *Watcher) updateBackendList(ctx context.Context) error {
-	pods, err := w.clientset.CoreV1().Pods(w.namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: w.labelSelector,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to list pods: %w", err)
+	namespaces := w.namespaces
+	if w.nsSelector != "" {
+		nsList, err := w.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+			LabelSelector: w.nsSelector,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list namespaces: %w", err)
+		}
+		namespaces = make([]string, 0, len(nsList.Items))
+		for i := range nsList.Items {
+			namespaces = append(namespaces, nsList.Items[i].Name)
+		}
+	}
+
+	var items []*corev1.Pod
+	for _, namespace := range namespaces {
+		pods, err := w.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: w.labelSelector,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+		}
+		for i := range pods.Items {
+			items = append(items, &pods.Items[i])
+		}
 	}
 
-	backends := w.extractBackends(pods.Items)
+	w.publish(w.extractBackends(items))
+	return nil
+}
 
+// publish sends backends to the channel if they differ from the last published set
+func (w *Watcher) publish(backends []discovery.Backend) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
 	// Sort for comparison
-	sort.Strings(backends)
-	sort.Strings(w.lastBackends)
-
-	// Check if backends changed
-	if !equal(backends, w.lastBackends) {
-		slog.Info("Pod backends changed",
-			"old_count", len(w.lastBackends),
-			"new_count", len(backends))
+	discovery.SortBackends(backends)
 
-		w.lastBackends = backends
+	// Check if backends changed. The first set is always published so
+	// consumers learn about empty selections too.
+	if w.published && discovery.EqualBackends(backends, w.lastBackends) {
+		return
+	}
 
-		// Send to channel (non-blocking)
-		select {
-		case w.backendsChan <- backends:
-		default:
-			slog.Warn("Backend channel full, skipping update")
-		}
+	perNamespace := make(map[string]int)
+	for _, b := range backends {
+		perNamespace[b.Namespace]++
 	}
+	added, removed := discovery.Diff(w.lastBackends, backends)
 
-	return nil
+	slog.Info("Pod backends changed",
+		"old_count", len(w.lastBackends),
+		"new_count", len(backends),
+		"per_namespace", perNamespace,
+		"added", added,
+		"removed", removed)
+
+	w.lastBackends = backends
+	w.published = true
+
+	// Send to channel, replacing any stale state still queued
+	discovery.SendLatest(w.backendsChan, backends)
+}
+
+// sendError reports an error without blocking the caller
+func (w *Watcher) sendError(err error) {
+	select {
+	case w.errorChan <- err:
+	default:
+		slog.Warn("Error channel full, dropping error", "error", err)
+	}
 }
 
 // extractBackends extracts backend addresses from pod list
-func (w *Watcher) extractBackends(pods []corev1.Pod) []string {
-	var backends []string
-	for i := range pods {
-		pod := &pods[i]
-		if pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" {
-			backend := fmt.Sprintf("%s:%d", pod.Status.PodIP, w.backendPort)
-			backends = append(backends, backend)
+func (w *Watcher) extractBackends(pods []*corev1.Pod) []discovery.Backend {
+	defer w.forgetWarnings(pods)
+	var backends []discovery.Backend
+	for _, pod := range pods {
+		if !w.filter.AllowPod(pod) {
+			continue
+		}
+		port, ok := w.resolvePort(pod)
+		if !ok {
+			continue
 		}
+		backends = append(backends, discovery.Backend{
+			Address:   net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)),
+			Weight:    w.resolveWeight(pod),
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Node:      pod.Spec.NodeName,
+			Labels:    maps.Clone(pod.Labels),
+		})
 	}
 	return backends
 }
 
-// Close stops the watcher
-func (w *Watcher) Close() error {
-	close(w.stopChan)
-	return nil
+// resolveWeight returns the backend weight from the pod's weight annotation.
+// A missing or invalid annotation leaves the weight to the load balancer default.
+func (w *Watcher) resolveWeight(pod *corev1.Pod) int {
+	value, ok := pod.Annotations[w.weightAnno]
+	if !ok || w.weightAnno == "" {
+		return 0
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 1 {
+		if w.firstWarning(pod, w.weightAnno, value) {
+			slog.Warn("Ignoring invalid weight annotation",
+				"namespace", pod.Namespace, "pod", pod.Name, "annotation", w.weightAnno, "value", value)
+		}
+		return 0
+	}
+	return weight
 }
 
-// equal compares two string slices (assumes both are sorted)
-func equal(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
+// resolvePort returns the backend port for a pod. The port annotation wins over
+// the named container port, which wins over the global backend port. Pods that
+// don't expose the requested port are skipped.
+func (w *Watcher) resolvePort(pod *corev1.Pod) (int, bool) {
+	portName := w.portName
+	if value, ok := pod.Annotations[w.portAnnotation]; ok && w.portAnnotation != "" {
+		if port, err := strconv.Atoi(value); err == nil {
+			if port < 1 || port > 65535 {
+				if w.firstWarning(pod, w.portAnnotation, value) {
+					slog.Warn("Ignoring pod with out of range port annotation",
+						"namespace", pod.Namespace, "pod", pod.Name, "annotation", w.portAnnotation, "value", value)
+				}
+				return 0, false
+			}
+			return port, true
+		}
+		// Not a number, treat it as a container port name
+		portName = value
 	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+
+	if portName == "" {
+		return w.backendPort, true
+	}
+
+	for i := range pod.Spec.Containers {
+		for _, p := range pod.Spec.Containers[i].Ports {
+			if p.Name == portName {
+				return int(p.ContainerPort), true
+			}
 		}
 	}
+
+	slog.Debug("Skipping pod without named port", "namespace", pod.Namespace, "pod", pod.Name, "port_name", portName)
+	return 0, false
+}
+
+// firstWarning reports whether an invalid annotation value of a pod is seen
+// for the first time, so it is logged once instead of on every recompute
+func (w *Watcher) firstWarning(pod *corev1.Pod, annotation, value string) bool {
+	key := annotationWarning{uid: pod.UID, annotation: annotation, value: value}
+	w.warnMu.Lock()
+	defer w.warnMu.Unlock()
+	if w.warned[key] {
+		return false
+	}
+	w.warned[key] = true
 	return true
 }
+
+// forgetWarnings drops the logged warnings of pods that are gone
+func (w *Watcher) forgetWarnings(pods []*corev1.Pod) {
+	current := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		current[pod.UID] = true
+	}
+	w.warnMu.Lock()
+	defer w.warnMu.Unlock()
+	for key := range w.warned {
+		if !current[key.uid] {
+			delete(w.warned, key)
+		}
+	}
+}
+
+// Close stops the watcher
+func (w *Watcher) Close() error {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+	})
+	return nil
+}
@@ -0,0 +1,296 @@
+package podwatcher
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPod(name, ip string, phase corev1.PodPhase) *corev1.Pod {
+	return newPodIn("default", name, ip, phase)
+}
+
+func newPodIn(namespace, name, ip string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app": "relay"},
+		},
+		Status: corev1.PodStatus{
+			Phase:      phase,
+			PodIP:      ip,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func addresses(backends []discovery.Backend) string {
+	result := make([]string, 0, len(backends))
+	for _, b := range backends {
+		result = append(result, b.Address)
+	}
+	return fmt.Sprint(result)
+}
+
+func testOptions(useWatch bool) Options {
+	return Options{
+		Namespaces:     []string{"default"},
+		LabelSelector:  "app=relay",
+		BackendPort:    3333,
+		UpdateInterval: time.Second,
+		UseWatch:       useWatch,
+		Filter:         discovery.DefaultFilter(),
+	}
+}
+
+func waitForBackends(t *testing.T, ch <-chan []discovery.Backend, want []string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-ch:
+			if addresses(got) == fmt.Sprint(want) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for backends %v", want)
+		}
+	}
+}
+
+func TestWatcherInformer(t *testing.T) {
+	clientset := fake.NewClientset(
+		newPod("relay-1", "10.0.0.1", corev1.PodRunning),
+		newPod("relay-2", "10.0.0.2", corev1.PodPending),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := New(clientset, testOptions(true))
+	backends, _ := w.Watch(ctx)
+
+	waitForBackends(t, backends, []string{"10.0.0.1:3333"})
+
+	// A new running pod is picked up from the watch stream
+	_, err := clientset.CoreV1().Pods("default").Create(ctx,
+		newPod("relay-3", "10.0.0.3", corev1.PodRunning), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+	waitForBackends(t, backends, []string{"10.0.0.1:3333", "10.0.0.3:3333"})
+
+	// Backends carry the pod they came from
+	w.mu.RLock()
+	current := w.lastBackends
+	w.mu.RUnlock()
+	if current[0].Name != "relay-1" || current[0].Namespace != "default" || current[0].Labels["app"] != "relay" {
+		t.Errorf("backend metadata = %+v", current[0])
+	}
+
+	// Deleting a pod removes it from the backend list
+	if err := clientset.CoreV1().Pods("default").Delete(ctx, "relay-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	waitForBackends(t, backends, []string{"10.0.0.3:3333"})
+
+	// Watch events are served from the cache: only the informer's initial LIST hits the API
+	lists := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "pods" {
+			lists++
+		}
+	}
+	if lists != 1 {
+		t.Errorf("expected 1 pod LIST call, got %d", lists)
+	}
+}
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestWatcherNamespaces(t *testing.T) {
+	tenant := map[string]string{"tenant": "true"}
+	objects := []runtime.Object{
+		newNamespace("tenant-a", tenant),
+		newNamespace("tenant-b", tenant),
+		newNamespace("other", nil),
+		newPodIn("tenant-a", "relay-a", "10.0.1.1", corev1.PodRunning),
+		newPodIn("tenant-b", "relay-b", "10.0.2.1", corev1.PodRunning),
+		newPodIn("other", "relay-c", "10.0.3.1", corev1.PodRunning),
+	}
+
+	tests := []struct {
+		name     string
+		useWatch bool
+		opts     func(*Options)
+		want     []string
+	}{
+		{
+			name:     "namespace list with informer",
+			useWatch: true,
+			opts:     func(o *Options) { o.Namespaces = []string{"tenant-a", "other"} },
+			want:     []string{"10.0.1.1:3333", "10.0.3.1:3333"},
+		},
+		{
+			name:     "namespace selector with informer",
+			useWatch: true,
+			opts:     func(o *Options) { o.NamespaceSelector = "tenant=true" },
+			want:     []string{"10.0.1.1:3333", "10.0.2.1:3333"},
+		},
+		{
+			name:     "namespace selector with polling",
+			useWatch: false,
+			opts:     func(o *Options) { o.NamespaceSelector = "tenant=true" },
+			want:     []string{"10.0.1.1:3333", "10.0.2.1:3333"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			opts := testOptions(tt.useWatch)
+			tt.opts(&opts)
+			w := New(fake.NewClientset(objects...), opts)
+			backends, _ := w.Watch(ctx)
+
+			waitForBackends(t, backends, tt.want)
+		})
+	}
+}
+
+func TestWatcherPolling(t *testing.T) {
+	clientset := fake.NewClientset(
+		newPod("relay-1", "10.0.0.1", corev1.PodRunning),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := New(clientset, testOptions(false))
+	backends, _ := w.Watch(ctx)
+
+	waitForBackends(t, backends, []string{"10.0.0.1:3333"})
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// Close is idempotent
+	if err := w.Close(); err != nil {
+		t.Fatalf("second Close() error = %v", err)
+	}
+}
+
+func TestResolvePort(t *testing.T) {
+	withPorts := func(annotations map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+		pod := newPod("relay", "10.0.0.1", corev1.PodRunning)
+		pod.Annotations = annotations
+		pod.Spec.Containers = []corev1.Container{{Name: "relay", Ports: ports}}
+		return pod
+	}
+	relayPort := corev1.ContainerPort{Name: "relay", ContainerPort: 4444}
+	altPort := corev1.ContainerPort{Name: "relay-v2", ContainerPort: 5555}
+	annotation := "ilb.tazhate.io/port"
+
+	tests := []struct {
+		name     string
+		portName string
+		pod      *corev1.Pod
+		want     int
+		wantOK   bool
+	}{
+		{"global port", "", withPorts(nil, relayPort), 3333, true},
+		{"named port", "relay", withPorts(nil, relayPort), 4444, true},
+		{"named port missing", "relay", withPorts(nil, altPort), 0, false},
+		{"numeric annotation", "relay", withPorts(map[string]string{annotation: "6666"}, relayPort), 6666, true},
+		{"named annotation", "relay", withPorts(map[string]string{annotation: "relay-v2"}, relayPort, altPort), 5555, true},
+		{"invalid annotation", "", withPorts(map[string]string{annotation: "70000"}, relayPort), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(true)
+			opts.PortName = tt.portName
+			opts.PortAnnotation = annotation
+			w := New(nil, opts)
+
+			got, ok := w.resolvePort(tt.pod)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("resolvePort() = %d, %v, want %d, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAnnotationWarningOnce(t *testing.T) {
+	w := New(nil, testOptions(true))
+	pod := newPod("relay", "10.0.0.1", corev1.PodRunning)
+	pod.UID = "uid-1"
+
+	if !w.firstWarning(pod, "ilb.tazhate.io/port", "70000") {
+		t.Error("first warning not reported")
+	}
+	if w.firstWarning(pod, "ilb.tazhate.io/port", "70000") {
+		t.Error("repeated warning reported again")
+	}
+	if !w.firstWarning(pod, "ilb.tazhate.io/port", "80000") {
+		t.Error("warning for a new value not reported")
+	}
+
+	// Warnings of pods that are gone are forgotten
+	w.extractBackends(nil)
+	if !w.firstWarning(pod, "ilb.tazhate.io/port", "70000") {
+		t.Error("warning not reported again after the pod was gone")
+	}
+}
+
+func TestResolveWeight(t *testing.T) {
+	annotation := "ilb.tazhate.io/weight"
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        int
+	}{
+		{"no annotation", nil, 0},
+		{"weight", map[string]string{annotation: "50"}, 50},
+		{"invalid weight", map[string]string{annotation: "heavy"}, 0},
+		{"zero weight", map[string]string{annotation: "0"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions(true)
+			opts.WeightAnnotation = annotation
+			w := New(nil, opts)
+
+			pod := newPod("relay", "10.0.0.1", corev1.PodRunning)
+			pod.Annotations = tt.annotations
+			if got := w.resolveWeight(pod); got != tt.want {
+				t.Errorf("resolveWeight() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// An invalid weight is logged once, not on every recompute
+	opts := testOptions(true)
+	opts.WeightAnnotation = annotation
+	w := New(nil, opts)
+	pod := newPod("relay", "10.0.0.1", corev1.PodRunning)
+	pod.Annotations = map[string]string{annotation: "heavy"}
+	w.resolveWeight(pod)
+	if w.firstWarning(pod, annotation, "heavy") {
+		t.Error("invalid weight annotation not recorded as warned")
+	}
+}
@@ -0,0 +1,240 @@
+package reconciler
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/interfaces"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// Metric names reported by the reconciler
+const (
+	MetricApplied     = "reconcile_applied_total"
+	MetricApplyErrors = "reconcile_apply_errors_total"
+	MetricRetries     = "reconcile_retries_total"
+	MetricDrift       = "reconcile_drift_total"
+	MetricDriftErrors = "reconcile_drift_check_errors_total"
+)
+
+// Default backoff bounds, used when a Backoff leaves them unset
+const (
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+)
+
+// Backoff is an exponential backoff with jitter
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns the wait before the given retry attempt (starting at 0). The
+// base delay doubles per attempt up to Max; the result is jittered between
+// half and all of it so that retries don't line up with the breaker timeout.
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b.Initial <= 0 {
+		b.Initial = defaultInitialBackoff
+	}
+	if b.Max <= 0 {
+		b.Max = max(defaultMaxBackoff, b.Initial)
+	}
+	d := b.Initial
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+// Reconciler keeps the load balancer converged on the desired backends.
+// Changes are applied as soon as they are set. The latest desired state is
+// never dropped: a failed apply, e.g. while the circuit breaker is open, is
+// retried with exponential backoff until it succeeds or a newer state
+// replaces it. On every interval, if the backend can detect drift, the live
+// state is compared with the desired one and re-applied when they differ
+// (e.g. after Traefik restarted and lost its in-memory REST configuration).
+type Reconciler struct {
+	backend  interfaces.LoadBalancerBackend
+	interval time.Duration
+	backoff  Backoff
+
+	// OnApplied is called after every successful apply
+	OnApplied func(ctx context.Context, backends []discovery.Backend)
+
+	mu         sync.Mutex
+	desired    []discovery.Backend
+	generation uint64
+	dirty      bool
+	trigger    chan struct{}
+}
+
+// New creates a reconciler. An interval of 0 disables periodic drift checks.
+func New(backend interfaces.LoadBalancerBackend, interval time.Duration, backoff Backoff) *Reconciler {
+	return &Reconciler{
+		backend:  backend,
+		interval: interval,
+		backoff:  backoff,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// SetDesired replaces the desired backends and schedules an apply
+func (r *Reconciler) SetDesired(backends []discovery.Backend) {
+	r.mu.Lock()
+	r.desired = backends
+	r.generation++
+	r.dirty = true
+	r.mu.Unlock()
+
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Resync schedules a re-apply of the current desired backends, e.g. after a
+// rendering setting changed. It does nothing before the first SetDesired.
+func (r *Reconciler) Resync() {
+	r.mu.Lock()
+	if r.generation == 0 {
+		r.mu.Unlock()
+		return
+	}
+	r.generation++
+	r.dirty = true
+	r.mu.Unlock()
+
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run applies desired state changes and reconciles periodically until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var (
+		attempt int
+		retry   *time.Timer
+		retryC  <-chan time.Time
+	)
+	defer func() {
+		if retry != nil {
+			retry.Stop()
+		}
+	}()
+
+	// schedule arms the retry timer after a failed apply and disarms it after a success
+	schedule := func(err error) {
+		if err == nil {
+			attempt = 0
+			if retry != nil {
+				retry.Stop()
+			}
+			retryC = nil
+			return
+		}
+		delay := r.backoff.Delay(attempt)
+		attempt++
+		slog.Warn("Scheduling backend update retry",
+			"attempt", attempt,
+			"delay", delay)
+		if retry == nil {
+			retry = time.NewTimer(delay)
+		} else {
+			retry.Reset(delay)
+		}
+		retryC = retry.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.trigger:
+			schedule(r.apply(ctx, "changed"))
+		case <-retryC:
+			retryC = nil
+			metrics.Inc(MetricRetries)
+			schedule(r.apply(ctx, "retry"))
+		case <-tick:
+			// Pending retries follow their own backoff
+			if retryC != nil {
+				continue
+			}
+			schedule(r.reconcile(ctx))
+		}
+	}
+}
+
+// reconcile applies a still dirty state or re-applies when the live state
+// drifted. Only apply errors are returned; a failed drift check is just logged.
+func (r *Reconciler) reconcile(ctx context.Context) error {
+	r.mu.Lock()
+	generation, dirty := r.generation, r.dirty
+	r.mu.Unlock()
+
+	if generation == 0 {
+		return nil
+	}
+	if dirty {
+		return r.apply(ctx, "retry")
+	}
+
+	detector, ok := r.backend.(interfaces.DriftDetector)
+	if !ok {
+		return nil
+	}
+	drift, err := detector.CheckDrift(ctx)
+	if err != nil {
+		metrics.Inc(MetricDriftErrors)
+		slog.Warn("Failed to check for configuration drift", "error", err)
+		return nil
+	}
+	if len(drift) == 0 {
+		slog.Debug("No configuration drift")
+		return nil
+	}
+
+	metrics.Inc(MetricDrift)
+	slog.Warn("Configuration drift detected, re-applying", "differences", drift)
+	return r.apply(ctx, "drift")
+}
+
+// apply pushes the desired backends, leaving them dirty on failure
+func (r *Reconciler) apply(ctx context.Context, reason string) error {
+	r.mu.Lock()
+	backends, generation := r.desired, r.generation
+	r.mu.Unlock()
+
+	if err := r.backend.UpdateBackends(ctx, backends); err != nil {
+		metrics.Inc(MetricApplyErrors)
+		slog.Error("Failed to update backends", "error", err, "reason", reason)
+		return err
+	}
+	metrics.Inc(MetricApplied)
+
+	// Only clear dirty if no newer state arrived meanwhile
+	r.mu.Lock()
+	if r.generation == generation {
+		r.dirty = false
+	}
+	r.mu.Unlock()
+
+	if r.OnApplied != nil {
+		r.OnApplied(ctx, backends)
+	}
+	return nil
+}
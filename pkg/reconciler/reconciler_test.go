@@ -0,0 +1,193 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+var testBackoff = Backoff{Initial: 5 * time.Millisecond, Max: 20 * time.Millisecond}
+
+// fakeBackend records applies and reports configurable drift
+type fakeBackend struct {
+	mu      sync.Mutex
+	applied [][]discovery.Backend
+	fail    bool
+	drift   []string
+}
+
+func (f *fakeBackend) UpdateBackends(_ context.Context, backends []discovery.Backend) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail {
+		return errors.New("traefik unavailable")
+	}
+	f.applied = append(f.applied, backends)
+	f.drift = nil
+	return nil
+}
+
+func (f *fakeBackend) HealthCheck(context.Context) error { return nil }
+
+func (f *fakeBackend) CheckDrift(context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.drift, nil
+}
+
+func (f *fakeBackend) applyCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.applied)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReconcilerAppliesChanges(t *testing.T) {
+	backend := &fakeBackend{}
+	r := New(backend, 0, testBackoff)
+	var applied int
+	var mu sync.Mutex
+	r.OnApplied = func(context.Context, []discovery.Backend) {
+		mu.Lock()
+		applied++
+		mu.Unlock()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	r.SetDesired([]discovery.Backend{{Address: "10.0.0.1:3333"}})
+	waitFor(t, "apply", func() bool { return backend.applyCount() == 1 })
+	waitFor(t, "OnApplied", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return applied == 1
+	})
+}
+
+func TestReconcilerReappliesOnDrift(t *testing.T) {
+	backend := &fakeBackend{}
+	r := New(backend, 20*time.Millisecond, testBackoff)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	r.SetDesired([]discovery.Backend{{Address: "10.0.0.1:3333"}})
+	waitFor(t, "initial apply", func() bool { return backend.applyCount() == 1 })
+
+	// No drift: nothing is re-applied
+	time.Sleep(60 * time.Millisecond)
+	if n := backend.applyCount(); n != 1 {
+		t.Fatalf("applies without drift = %d, want 1", n)
+	}
+
+	// Traefik restarted and lost its configuration
+	backend.mu.Lock()
+	backend.drift = []string{"router relay-router is missing"}
+	backend.mu.Unlock()
+	waitFor(t, "re-apply after drift", func() bool { return backend.applyCount() == 2 })
+}
+
+func TestReconcilerRetriesFailedApply(t *testing.T) {
+	backend := &fakeBackend{fail: true}
+	// Periodic reconciliation disabled: the retry comes from the backoff alone
+	r := New(backend, 0, testBackoff)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	r.SetDesired([]discovery.Backend{{Address: "10.0.0.1:3333"}})
+	time.Sleep(30 * time.Millisecond)
+
+	backend.mu.Lock()
+	backend.fail = false
+	backend.mu.Unlock()
+	waitFor(t, "retried apply", func() bool { return backend.applyCount() == 1 })
+}
+
+func TestReconcilerRetriesLatestState(t *testing.T) {
+	backend := &fakeBackend{fail: true}
+	r := New(backend, 0, testBackoff)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Two states arrive while the backend is down: only the newest is applied
+	r.SetDesired([]discovery.Backend{{Address: "10.0.0.1:3333"}})
+	r.SetDesired([]discovery.Backend{{Address: "10.0.0.2:3333"}})
+	time.Sleep(30 * time.Millisecond)
+
+	backend.mu.Lock()
+	backend.fail = false
+	backend.mu.Unlock()
+	waitFor(t, "retried apply", func() bool { return backend.applyCount() >= 1 })
+
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+	last := backend.applied[len(backend.applied)-1]
+	if len(last) != 1 || last[0].Address != "10.0.0.2:3333" {
+		t.Errorf("applied %v, want the newest state", last)
+	}
+}
+
+func TestReconcilerResync(t *testing.T) {
+	backend := &fakeBackend{}
+	r := New(backend, 0, testBackoff)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Run(ctx)
+
+	// Nothing to re-apply before the first desired state
+	r.Resync()
+	time.Sleep(20 * time.Millisecond)
+	if n := backend.applyCount(); n != 0 {
+		t.Fatalf("applies before SetDesired = %d, want 0", n)
+	}
+
+	r.SetDesired([]discovery.Backend{{Address: "10.0.0.1:3333"}})
+	waitFor(t, "initial apply", func() bool { return backend.applyCount() == 1 })
+
+	r.Resync()
+	waitFor(t, "resync", func() bool { return backend.applyCount() == 2 })
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{50, time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			d := b.Delay(tt.attempt)
+			if d < tt.base/2 || d > tt.base {
+				t.Errorf("Delay(%d) = %v, want within [%v, %v]", tt.attempt, d, tt.base/2, tt.base)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package slicewatcher
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// resyncPeriod is how often the informer replays its cache to the event handlers
+const resyncPeriod = 5 * time.Minute
+
+// Watcher follows the EndpointSlices of a Service and turns ready endpoints into backends
+type Watcher struct {
+	mu           sync.RWMutex
+	lastBackends []discovery.Backend
+	published    bool
+	namespace    string
+	serviceName  string
+	portName     string
+	filter       discovery.Filter
+	clientset    kubernetes.Interface
+	backendsChan chan []discovery.Backend
+	errorChan    chan error
+	stopChan     chan struct{}
+	stopOnce     sync.Once
+}
+
+// New creates a new EndpointSlice watcher for the given Service.
+// portName selects the Service port to use; when empty the first port of each slice is used.
+func New(clientset kubernetes.Interface, namespace, serviceName, portName string, filter discovery.Filter) *Watcher {
+	return &Watcher{
+		clientset:    clientset,
+		namespace:    namespace,
+		serviceName:  serviceName,
+		portName:     portName,
+		filter:       filter,
+		backendsChan: make(chan []discovery.Backend, 10),
+		errorChan:    make(chan error, 10),
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Watch starts watching EndpointSlices of the Service
+func (w *Watcher) Watch(ctx context.Context) (backends <-chan []discovery.Backend, errors <-chan error) {
+	go w.run(ctx)
+	return w.backendsChan, w.errorChan
+}
+
+// run keeps a local EndpointSlice cache in sync and recomputes backends on every change
+func (w *Watcher) run(ctx context.Context) {
+	slog.Info("Starting EndpointSlice watcher",
+		"namespace", w.namespace,
+		"service", w.serviceName,
+		"port_name", w.portName)
+
+	defer func() {
+		slog.Info("EndpointSlice watcher stopped")
+		close(w.backendsChan)
+		close(w.errorChan)
+	}()
+
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.stopChan:
+		}
+		close(stopCh)
+	}()
+
+	selector := labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: w.serviceName}).String()
+	factory := informers.NewSharedInformerFactoryWithOptions(w.clientset, resyncPeriod,
+		informers.WithNamespace(w.namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}),
+	)
+	sliceInformer := factory.Discovery().V1().EndpointSlices()
+	informer := sliceInformer.Informer()
+	lister := sliceInformer.Lister()
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		slog.Warn("EndpointSlice watch interrupted, informer will relist", "error", err)
+		w.sendError(fmt.Errorf("endpointslice watch error: %w", err))
+	}); err != nil {
+		w.sendError(fmt.Errorf("failed to set watch error handler: %w", err))
+	}
+
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(any) { notify() },
+		UpdateFunc: func(_, _ any) { notify() },
+		DeleteFunc: func(any) { notify() },
+	}); err != nil {
+		w.sendError(fmt.Errorf("failed to register endpointslice event handler: %w", err))
+		return
+	}
+
+	factory.Start(stopCh)
+	defer factory.Shutdown()
+
+	if !cache.WaitForCacheSync(stopCh, informer.HasSynced) {
+		return
+	}
+	slog.Info("EndpointSlice cache synced")
+
+	if err := w.updateFromCache(lister); err != nil {
+		slog.Error("Failed initial endpoint discovery", "error", err)
+		w.sendError(err)
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-changed:
+			if err := w.updateFromCache(lister); err != nil {
+				slog.Error("Failed to update backend list", "error", err)
+				w.sendError(err)
+			}
+		}
+	}
+}
+
+// updateFromCache recomputes backends from the informer cache
+func (w *Watcher) updateFromCache(lister listersv1.EndpointSliceLister) error {
+	slices, err := lister.EndpointSlices(w.namespace).List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list endpointslices from cache: %w", err)
+	}
+
+	w.publish(w.extractBackends(slices))
+	return nil
+}
+
+// extractBackends extracts endpoint addresses admitted by the filter from the slices.
+// An endpoint may briefly appear in more than one slice, so addresses are deduplicated.
+func (w *Watcher) extractBackends(slices []*discoveryv1.EndpointSlice) []discovery.Backend {
+	seen := make(map[string]struct{})
+	var backends []discovery.Backend
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		port, ok := w.slicePort(slice)
+		if !ok {
+			continue
+		}
+		for i := range slice.Endpoints {
+			endpoint := &slice.Endpoints[i]
+			if !w.filter.AllowEndpoint(endpoint.Conditions) {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				addr := net.JoinHostPort(address, strconv.Itoa(int(port)))
+				if _, dup := seen[addr]; dup {
+					continue
+				}
+				seen[addr] = struct{}{}
+				backend := discovery.Backend{
+					Address:   addr,
+					Namespace: slice.Namespace,
+				}
+				if ref := endpoint.TargetRef; ref != nil && ref.Kind == "Pod" {
+					backend.Name = ref.Name
+					if ref.Namespace != "" {
+						backend.Namespace = ref.Namespace
+					}
+				}
+				if endpoint.NodeName != nil {
+					backend.Node = *endpoint.NodeName
+				}
+				if endpoint.Zone != nil {
+					backend.Zone = *endpoint.Zone
+				}
+				backends = append(backends, backend)
+			}
+		}
+	}
+	return backends
+}
+
+// slicePort returns the port of the slice matching the configured port name
+func (w *Watcher) slicePort(slice *discoveryv1.EndpointSlice) (int32, bool) {
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		if w.portName == "" || (port.Name != nil && *port.Name == w.portName) {
+			return *port.Port, true
+		}
+	}
+	return 0, false
+}
+
+// publish sends backends to the channel if they differ from the last published set
+func (w *Watcher) publish(backends []discovery.Backend) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	discovery.SortBackends(backends)
+	// The first set is always published so empty groups are known too
+	if w.published && discovery.EqualBackends(backends, w.lastBackends) {
+		return
+	}
+
+	added, removed := discovery.Diff(w.lastBackends, backends)
+	slog.Info("Endpoint backends changed",
+		"old_count", len(w.lastBackends),
+		"new_count", len(backends),
+		"added", added,
+		"removed", removed)
+
+	w.lastBackends = backends
+	w.published = true
+
+	// Send to channel, replacing any stale state still queued
+	discovery.SendLatest(w.backendsChan, backends)
+}
+
+// sendError reports an error without blocking the caller
+func (w *Watcher) sendError(err error) {
+	select {
+	case w.errorChan <- err:
+	default:
+		slog.Warn("Error channel full, dropping error", "error", err)
+	}
+}
+
+// Close stops the watcher
+func (w *Watcher) Close() error {
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+	})
+	return nil
+}
@@ -0,0 +1,120 @@
+package slicewatcher
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestExtractBackends(t *testing.T) {
+	slices := []*discoveryv1.EndpointSlice{
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "relay-abc", Namespace: "default"},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports: []discoveryv1.EndpointPort{
+				{Name: ptr("metrics"), Port: ptr(int32(9090))},
+				{Name: ptr("relay"), Port: ptr(int32(3333))},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{
+					Addresses:  []string{"10.0.0.1"},
+					Conditions: discoveryv1.EndpointConditions{Ready: ptr(true)},
+					TargetRef:  &corev1.ObjectReference{Kind: "Pod", Name: "relay-1", Namespace: "default"},
+					NodeName:   ptr("node-a"),
+					Zone:       ptr("zone-a"),
+				},
+				{Addresses: []string{"10.0.0.2"}, Conditions: discoveryv1.EndpointConditions{Ready: ptr(false)}},
+				// nil ready is treated as ready
+				{Addresses: []string{"10.0.0.3"}},
+			},
+		},
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "relay-def", Namespace: "default"},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Ports:       []discoveryv1.EndpointPort{{Name: ptr("relay"), Port: ptr(int32(3333))}},
+			Endpoints: []discoveryv1.Endpoint{
+				// Duplicate of an endpoint in the first slice
+				{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: ptr(true)}},
+			},
+		},
+		{
+			ObjectMeta:  metav1.ObjectMeta{Name: "relay-v6", Namespace: "default"},
+			AddressType: discoveryv1.AddressTypeIPv6,
+			Ports:       []discoveryv1.EndpointPort{{Name: ptr("relay"), Port: ptr(int32(3333))}},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"fd00::1"}, Conditions: discoveryv1.EndpointConditions{Ready: ptr(true)}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		portName string
+		want     []string
+	}{
+		{
+			name:     "named port",
+			portName: "relay",
+			want:     []string{"10.0.0.1:3333", "10.0.0.3:3333", "[fd00::1]:3333"},
+		},
+		{
+			name:     "first port",
+			portName: "",
+			want:     []string{"10.0.0.1:9090", "10.0.0.3:9090", "10.0.0.1:3333", "[fd00::1]:3333"},
+		},
+		{
+			name:     "unknown port",
+			portName: "missing",
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := New(nil, "default", "relay", tt.portName, discovery.DefaultFilter())
+			var got []string
+			for _, b := range w.extractBackends(slices) {
+				got = append(got, b.Address)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("extractBackends() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBackendsMetadata(t *testing.T) {
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta:  metav1.ObjectMeta{Name: "relay-abc", Namespace: "default"},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: ptr("relay"), Port: ptr(int32(3333))}},
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses: []string{"10.0.0.1"},
+			TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "relay-1", Namespace: "default"},
+			NodeName:  ptr("node-a"),
+			Zone:      ptr("zone-a"),
+		}},
+	}
+
+	w := New(nil, "default", "relay", "relay", discovery.DefaultFilter())
+	got := w.extractBackends([]*discoveryv1.EndpointSlice{slice})
+	want := discovery.Backend{
+		Address:   "10.0.0.1:3333",
+		Name:      "relay-1",
+		Namespace: "default",
+		Node:      "node-a",
+		Zone:      "zone-a",
+	}
+	if len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("extractBackends() = %+v, want %+v", got, want)
+	}
+}
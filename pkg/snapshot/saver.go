@@ -0,0 +1,103 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+// defaultSaveTimeout bounds a single save, e.g. a ConfigMap update against a slow API
+const defaultSaveTimeout = 10 * time.Second
+
+// Saver persists snapshots in the background, so a slow store never delays
+// the next push. While a save runs only the latest snapshot is queued, and a
+// snapshot equal to the last saved one, apart from SavedAt, is skipped.
+type Saver struct {
+	store   Store
+	timeout time.Duration
+	queue   chan *Snapshot
+}
+
+// NewSaver creates a background saver writing to store
+func NewSaver(store Store) *Saver {
+	return &Saver{
+		store:   store,
+		timeout: defaultSaveTimeout,
+		queue:   make(chan *Snapshot, 1),
+	}
+}
+
+// Save queues a snapshot, replacing one not saved yet. It never blocks. Pod
+// labels are dropped: restoring doesn't need them, and they would take up
+// most of a ConfigMap's size limit in large groups.
+func (s *Saver) Save(snap *Snapshot) {
+	snap = withoutLabels(snap)
+	for {
+		select {
+		case s.queue <- snap:
+			return
+		default:
+		}
+		select {
+		case <-s.queue:
+			slog.Debug("Dropped unsaved snapshot in favor of a newer one")
+		default:
+		}
+	}
+}
+
+// Run saves queued snapshots until ctx is cancelled
+func (s *Saver) Run(ctx context.Context) {
+	var lastHash [sha256.Size]byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap := <-s.queue:
+			hash, err := contentHash(snap)
+			if err != nil {
+				slog.Warn("Failed to save snapshot", "error", err)
+				continue
+			}
+			if hash == lastHash {
+				slog.Debug("Skipped snapshot save, content unchanged")
+				continue
+			}
+
+			saveCtx, cancel := context.WithTimeout(ctx, s.timeout)
+			err = s.store.Save(saveCtx, snap)
+			cancel()
+			if err != nil {
+				slog.Warn("Failed to save snapshot", "error", err)
+				continue
+			}
+			lastHash = hash
+		}
+	}
+}
+
+// withoutLabels returns a copy of snap whose backends carry no pod labels
+func withoutLabels(snap *Snapshot) *Snapshot {
+	stripped := *snap
+	stripped.Backends = make([]discovery.Backend, len(snap.Backends))
+	for i, b := range snap.Backends {
+		b.Labels = nil
+		stripped.Backends[i] = b
+	}
+	return &stripped
+}
+
+// contentHash hashes a snapshot without its timestamp
+func contentHash(snap *Snapshot) ([sha256.Size]byte, error) {
+	content := *snap
+	content.SavedAt = time.Time{}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+// recordingStore records saved snapshots, blocking each save until released
+type recordingStore struct {
+	mu      sync.Mutex
+	saved   []*Snapshot
+	release chan struct{}
+}
+
+func (r *recordingStore) Load(context.Context) (*Snapshot, error) {
+	return nil, nil
+}
+
+func (r *recordingStore) Save(ctx context.Context, snap *Snapshot) error {
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saved = append(r.saved, snap)
+	return nil
+}
+
+func (r *recordingStore) addresses() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var addresses []string
+	for _, snap := range r.saved {
+		addresses = append(addresses, snap.Backends[0].Address)
+	}
+	return addresses
+}
+
+func snapshotOf(address string) *Snapshot {
+	return &Snapshot{
+		Backends: []discovery.Backend{{Group: "default", Address: address}},
+		SavedAt:  time.Now(),
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSaverKeepsOnlyLatest(t *testing.T) {
+	store := &recordingStore{release: make(chan struct{})}
+	saver := NewSaver(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go saver.Run(ctx)
+
+	// The first save blocks in the store; Save itself never does
+	saver.Save(snapshotOf("10.0.0.1:3333"))
+	waitFor(t, func() bool { return len(saver.queue) == 0 })
+	saver.Save(snapshotOf("10.0.0.2:3333"))
+	saver.Save(snapshotOf("10.0.0.3:3333"))
+
+	store.release <- struct{}{}
+	store.release <- struct{}{}
+	waitFor(t, func() bool { return len(store.addresses()) == 2 })
+	if got := store.addresses(); got[0] != "10.0.0.1:3333" || got[1] != "10.0.0.3:3333" {
+		t.Errorf("saved = %v, want the first and the latest snapshot", got)
+	}
+
+	// Same content with a new timestamp is not saved again
+	saver.Save(snapshotOf("10.0.0.3:3333"))
+	select {
+	case store.release <- struct{}{}:
+		t.Error("unchanged snapshot was saved")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSaverTimeout(t *testing.T) {
+	store := &recordingStore{release: make(chan struct{})}
+	saver := NewSaver(store)
+	saver.timeout = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go saver.Run(ctx)
+
+	// A hung save is abandoned and the same content is retried on the next snapshot
+	saver.Save(snapshotOf("10.0.0.1:3333"))
+	waitFor(t, func() bool { return len(saver.queue) == 0 })
+	time.Sleep(50 * time.Millisecond)
+
+	saver.Save(snapshotOf("10.0.0.1:3333"))
+	store.release <- struct{}{}
+	waitFor(t, func() bool { return len(store.addresses()) == 1 })
+}
+
+func TestSaverDropsLabels(t *testing.T) {
+	store := &recordingStore{release: make(chan struct{})}
+	saver := NewSaver(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go saver.Run(ctx)
+
+	snap := snapshotOf("10.0.0.1:3333")
+	snap.Backends[0].Labels = map[string]string{"app": "relay"}
+	saver.Save(snap)
+	store.release <- struct{}{}
+	waitFor(t, func() bool { return len(store.addresses()) == 1 })
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if labels := store.saved[0].Backends[0].Labels; labels != nil {
+		t.Errorf("saved labels = %v, want none", labels)
+	}
+	if snap.Backends[0].Labels == nil {
+		t.Error("Save() changed the caller's backends")
+	}
+}
@@ -0,0 +1,170 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// configMapKey is the ConfigMap data key holding the snapshot
+const configMapKey = "snapshot.json"
+
+// Snapshot is the last backend set successfully pushed to the load balancer
+type Snapshot struct {
+	Backends []discovery.Backend `json:"backends"`
+	// Config is the rendered load balancer configuration that was pushed
+	Config json.RawMessage `json:"config,omitempty"`
+	// CanaryWeights are the canary percentages per group at the time of the push
+	CanaryWeights map[string]CanaryWeight `json:"canaryWeights,omitempty"`
+	SavedAt       time.Time               `json:"savedAt"`
+}
+
+// CanaryWeight is a group's canary percentage, possibly changed at runtime,
+// and the configured percentage it started from
+type CanaryWeight struct {
+	Configured int `json:"configured"`
+	Current    int `json:"current"`
+}
+
+// Store persists the last known good snapshot
+type Store interface {
+	// Load returns the stored snapshot, or nil when none has been saved yet
+	Load(ctx context.Context) (*Snapshot, error)
+	// Save replaces the stored snapshot
+	Save(ctx context.Context, snap *Snapshot) error
+}
+
+// FileStore keeps the snapshot in a local file
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a snapshot store backed by a file
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads the snapshot file
+func (f *FileStore) Load(_ context.Context) (*Snapshot, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+	return decode(data)
+}
+
+// Save writes the snapshot atomically through a temporary file
+func (f *FileStore) Save(_ context.Context, snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot file: %w", err)
+	}
+	return nil
+}
+
+// ConfigMapStore keeps the snapshot in a Kubernetes ConfigMap
+type ConfigMapStore struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapStore creates a snapshot store backed by a ConfigMap
+func NewConfigMapStore(clientset kubernetes.Interface, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Load reads the snapshot from the ConfigMap
+func (c *ConfigMapStore) Load(ctx context.Context) (*Snapshot, error) {
+	cm, err := c.clientset.CoreV1().ConfigMaps(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get snapshot configmap: %w", err)
+	}
+	data, ok := cm.Data[configMapKey]
+	if !ok {
+		return nil, nil
+	}
+	return decode([]byte(data))
+}
+
+// Save creates or updates the ConfigMap with the snapshot
+func (c *ConfigMapStore) Save(ctx context.Context, snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	configMaps := c.clientset.CoreV1().ConfigMaps(c.namespace)
+	cm, err := configMaps.Get(ctx, c.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.name,
+				Namespace: c.namespace,
+			},
+			Data: map[string]string{configMapKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create snapshot configmap: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get snapshot configmap: %w", err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[configMapKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update snapshot configmap: %w", err)
+	}
+	return nil
+}
+
+// decode parses a stored snapshot
+func decode(data []byte) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return &snap, nil
+}
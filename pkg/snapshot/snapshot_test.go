@@ -0,0 +1,66 @@
+package snapshot
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		Backends: []discovery.Backend{
+			{Group: "default", Address: "10.0.0.1:3333", Name: "relay-1", Namespace: "default", Weight: 10},
+		},
+		Config:        []byte(`{"tcp":{}}`),
+		CanaryWeights: map[string]CanaryWeight{"default": {Configured: 10, Current: 25}},
+		SavedAt:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	snap, err := store.Load(ctx)
+	if err != nil || snap != nil {
+		t.Fatalf("empty store Load() = %v, %v, want nil, nil", snap, err)
+	}
+
+	want := testSnapshot()
+	for i := 0; i < 2; i++ {
+		if err := store.Save(ctx, want); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	got, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !discovery.EqualBackends(got.Backends, want.Backends) {
+		t.Errorf("Backends = %+v, want %+v", got.Backends, want.Backends)
+	}
+	if string(got.Config) != string(want.Config) {
+		t.Errorf("Config = %s, want %s", got.Config, want.Config)
+	}
+	if !maps.Equal(got.CanaryWeights, want.CanaryWeights) {
+		t.Errorf("CanaryWeights = %v, want %v", got.CanaryWeights, want.CanaryWeights)
+	}
+	if !got.SavedAt.Equal(want.SavedAt) {
+		t.Errorf("SavedAt = %v, want %v", got.SavedAt, want.SavedAt)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, NewFileStore(filepath.Join(t.TempDir(), "snapshot.json")))
+}
+
+func TestConfigMapStore(t *testing.T) {
+	testStore(t, NewConfigMapStore(fake.NewClientset(), "default", "ilb-snapshot"))
+}
@@ -3,36 +3,70 @@ package traefik
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/circuitbreaker"
 	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// Push counters: payloads sent to Traefik versus skipped as unchanged
+const (
+	MetricPushesApplied = "traefik_pushes_applied_total"
+	MetricPushesSkipped = "traefik_pushes_skipped_total"
 )
 
 // Backend manages Traefik backend configuration
 type Backend struct {
+	mu             sync.RWMutex
+	lastPayload    []byte
+	priorPayload   []byte            // The payload before lastPayload, which Traefik may still serve while applying it
+	lastHash       [sha256.Size]byte // Hash of the last payload Traefik applied, zero when unknown
+	lastPushAt     time.Time
+	forceInterval  time.Duration // Push an unchanged payload anyway once the last push is this old, 0 never
 	apiURL         string
-	routerName     string
-	serviceName    string
-	lbMethod       string
+	rawDataURL     string
+	groups         []config.Group
+	canaryWeights  map[string]int // Current canary percentage per group, adjustable at runtime
+	mergeUpdates   bool           // Merge into the live REST provider config instead of replacing it
+	ownerPrefix    string
+	verifyTimeout  time.Duration // How long to wait for Traefik to apply a push, 0 disables verification
+	verifyInterval time.Duration
 	circuitBreaker *circuitbreaker.CircuitBreaker
 	client         *http.Client
 }
 
-// New creates a new Traefik backend manager
+// New creates a new Traefik backend manager talking to the API without credentials
 func New(cfg *config.Config) *Backend {
+	return NewWithTransport(cfg, NewTransport(nil))
+}
+
+// NewTransport creates the pooled transport used for the Traefik API. A nil
+// tlsConfig uses the system roots.
+func NewTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}
+}
+
+// NewWithTransport creates a Traefik backend manager sending API requests
+// through transport, e.g. one adding credentials
+func NewWithTransport(cfg *config.Config, transport http.RoundTripper) *Backend {
 	client := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-		},
+		Timeout:   30 * time.Second,
+		Transport: transport,
 	}
 
 	cb := circuitbreaker.New(
@@ -42,60 +76,311 @@ func New(cfg *config.Config) *Backend {
 		cfg.CBConsecutiveFailures,
 	)
 
+	groups := cfg.BackendGroups()
+	canaryWeights := make(map[string]int)
+	for i := range groups {
+		if groups[i].Canary != nil {
+			canaryWeights[groups[i].Name] = groups[i].Canary.Weight
+		}
+	}
+
 	return &Backend{
 		apiURL:         cfg.TraefikAPIURL,
-		routerName:     cfg.RouterName,
-		serviceName:    cfg.ServiceName,
-		lbMethod:       cfg.LoadBalancerMethod,
+		rawDataURL:     rawDataURL(cfg.TraefikAPIURL),
+		groups:         groups,
+		canaryWeights:  canaryWeights,
+		mergeUpdates:   cfg.MergeUpdates,
+		ownerPrefix:    cfg.OwnerPrefix,
+		verifyTimeout:  cfg.VerifyTimeout,
+		verifyInterval: defaultVerifyInterval,
+		forceInterval:  cfg.ForcePushInterval,
 		client:         client,
 		circuitBreaker: cb,
 	}
 }
 
 // UpdateBackends updates the Traefik backend servers
-func (b *Backend) UpdateBackends(ctx context.Context, backends []string) error {
+func (b *Backend) UpdateBackends(ctx context.Context, backends []discovery.Backend) error {
 	return b.circuitBreaker.Execute(func() error {
 		return b.updateBackendsInternal(ctx, backends)
 	})
 }
 
+// Restore pushes a previously rendered configuration, e.g. a last known good
+// snapshot loaded at startup before discovery has caught up
+func (b *Backend) Restore(ctx context.Context, payload []byte) error {
+	return b.circuitBreaker.Execute(func() error {
+		if err := b.put(ctx, payload); err != nil {
+			return err
+		}
+		slog.Info("Restored Traefik configuration from snapshot",
+			"circuit_breaker_state", b.circuitBreaker.State())
+		return nil
+	})
+}
+
+// LastApplied returns the last configuration Traefik accepted
+func (b *Backend) LastApplied() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastPayload
+}
+
+// recentPayloads returns the last two accepted configurations, newest first
+func (b *Backend) recentPayloads() [][]byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var payloads [][]byte
+	for _, payload := range [][]byte{b.lastPayload, b.priorPayload} {
+		if payload != nil {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads
+}
+
 // updateBackendsInternal performs the actual backend update
-func (b *Backend) updateBackendsInternal(ctx context.Context, backends []string) error {
-	// Build servers slice
-	servers := make([]map[string]string, 0, len(backends))
+func (b *Backend) updateBackendsInternal(ctx context.Context, backends []discovery.Backend) error {
+	jsonData, err := b.render(ctx, backends)
+	if err != nil {
+		return err
+	}
+
+	if b.unchanged(jsonData) {
+		metrics.Inc(MetricPushesSkipped)
+		slog.Info("Skipped Traefik update, configuration unchanged",
+			"backend_count", len(backends),
+			"group_count", len(b.groups))
+		return nil
+	}
+
+	if err := b.put(ctx, jsonData); err != nil {
+		return err
+	}
+
+	metrics.Inc(MetricPushesApplied)
+	slog.Info("Updated Traefik configuration",
+		"backend_count", len(backends),
+		"group_count", len(b.groups),
+		"circuit_breaker_state", b.circuitBreaker.State())
+
+	return nil
+}
+
+// unchanged reports whether payload is what Traefik last applied, and a
+// forced push isn't due yet
+func (b *Backend) unchanged(payload []byte) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.lastHash != sha256.Sum256(payload) {
+		return false
+	}
+	return b.forceInterval == 0 || time.Since(b.lastPushAt) < b.forceInterval
+}
+
+// forgetApplied makes the next update push even an unchanged payload, e.g.
+// after Traefik was found to have lost it
+func (b *Backend) forgetApplied() {
+	b.mu.Lock()
+	b.lastHash = [sha256.Size]byte{}
+	b.mu.Unlock()
+}
+
+// render builds the REST provider payload for the given backends. Groups
+// with only a placeholder keep the configuration Traefik already has.
+func (b *Backend) render(ctx context.Context, backends []discovery.Backend) ([]byte, error) {
+	known, unknown := discovery.SplitUnknown(backends)
+	cfg := b.buildConfiguration(known)
+	if len(unknown) > 0 {
+		var err error
+		if cfg, err = b.carryUnknownGroups(ctx, cfg, unknown); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Traefik configuration: %w", err)
+	}
+
+	jsonData, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return jsonData, nil
+}
+
+// buildConfiguration gives every group its own router and service, all in one configuration
+func (b *Backend) buildConfiguration(backends []discovery.Backend) *Configuration {
+	// Split backends by group
+	byGroup := make(map[string][]discovery.Backend, len(b.groups))
 	for _, backend := range backends {
-		servers = append(servers, map[string]string{
-			"address": backend,
-		})
-	}
-
-	// Build configuration
-	config := map[string]any{
-		"tcp": map[string]any{
-			"routers": map[string]any{
-				b.routerName: map[string]any{
-					"entryPoints": []string{"tcp"},
-					"rule":        "HostSNI(`*`)",
-					"service":     b.serviceName,
-				},
+		byGroup[backend.Group] = append(byGroup[backend.Group], backend)
+	}
+
+	canaryWeights := b.currentCanaryWeights()
+
+	cfg := &Configuration{}
+	for i := range b.groups {
+		group := &b.groups[i]
+		groupBackends := byGroup[group.Name]
+		for _, backend := range groupBackends {
+			slog.Debug("Traefik server",
+				"group", group.Name,
+				"protocol", group.Protocol,
+				"service", group.ServiceName,
+				"address", backend.Address,
+				"pod", backend.Name,
+				"namespace", backend.Namespace,
+				"node", backend.Node,
+				"zone", backend.Zone,
+				"weight", backend.Weight,
+				"canary", backend.Canary)
+		}
+
+		split := splitServices(group, groupBackends, canaryWeights[group.Name])
+		switch group.Protocol {
+		case config.ProtocolHTTP:
+			addHTTPGroup(cfg, group, split)
+		case config.ProtocolUDP:
+			addUDPGroup(cfg, group, split)
+		default:
+			addTCPGroup(cfg, group, split)
+		}
+	}
+	return cfg
+}
+
+// addTCPGroup renders a group as a TCP router and service
+func addTCPGroup(cfg *Configuration, group *config.Group, split serviceSplit) {
+	if cfg.TCP == nil {
+		cfg.TCP = &TCPConfiguration{
+			Routers:  make(map[string]*TCPRouter),
+			Services: make(map[string]*TCPService),
+		}
+	}
+
+	router := &TCPRouter{
+		EntryPoints: group.EntryPoints,
+		Rule:        group.Rule,
+		Priority:    group.Priority,
+		Service:     group.ServiceName,
+	}
+	if group.TLS != nil {
+		router.TLS = &RouterTCPTLSConfig{
+			Passthrough:  group.TLS.Passthrough,
+			Options:      group.TLS.Options,
+			CertResolver: group.TLS.CertResolver,
+		}
+	}
+	cfg.TCP.Routers[group.RouterName] = router
+
+	for name, backends := range split.loadBalancers {
+		servers := make([]TCPServer, 0, len(backends))
+		for _, backend := range backends {
+			// Zero leaves the weight to Traefik's default
+			servers = append(servers, TCPServer{
+				Address: backend.Address,
+				Weight:  backend.Weight,
+			})
+		}
+		cfg.TCP.Services[name] = &TCPService{
+			LoadBalancer: &TCPLoadBalancer{
+				Method:  group.LoadBalancerMethod,
+				Servers: servers,
 			},
-			"services": map[string]any{
-				b.serviceName: map[string]any{
-					"loadBalancer": map[string]any{
-						"method":  b.lbMethod,
-						"servers": servers,
-					},
-				},
+		}
+	}
+	if split.weighted != nil {
+		cfg.TCP.Services[group.ServiceName] = &TCPService{Weighted: split.weighted}
+	}
+}
+
+// addHTTPGroup renders a group as an HTTP router and service
+func addHTTPGroup(cfg *Configuration, group *config.Group, split serviceSplit) {
+	if cfg.HTTP == nil {
+		cfg.HTTP = &HTTPConfiguration{
+			Routers:  make(map[string]*HTTPRouter),
+			Services: make(map[string]*HTTPService),
+		}
+	}
+
+	router := &HTTPRouter{
+		EntryPoints: group.EntryPoints,
+		Rule:        group.Rule,
+		Priority:    group.Priority,
+		Service:     group.ServiceName,
+	}
+	if group.TLS != nil {
+		router.TLS = &RouterTLSConfig{
+			Options:      group.TLS.Options,
+			CertResolver: group.TLS.CertResolver,
+		}
+	}
+	cfg.HTTP.Routers[group.RouterName] = router
+
+	for name, backends := range split.loadBalancers {
+		servers := make([]HTTPServer, 0, len(backends))
+		for _, backend := range backends {
+			server := HTTPServer{URL: "http://" + backend.Address}
+			if backend.Weight > 0 {
+				server.Weight = &backend.Weight
+			}
+			servers = append(servers, server)
+		}
+		cfg.HTTP.Services[name] = &HTTPService{
+			LoadBalancer: &HTTPLoadBalancer{
+				Servers:        servers,
+				PassHostHeader: group.PassHostHeader,
 			},
-		},
+		}
+	}
+	if split.weighted != nil {
+		cfg.HTTP.Services[group.ServiceName] = &HTTPService{Weighted: split.weighted}
+	}
+}
+
+// addUDPGroup renders a group as a UDP router and service. UDP servers carry
+// no weight, so backend weights are ignored.
+func addUDPGroup(cfg *Configuration, group *config.Group, split serviceSplit) {
+	if cfg.UDP == nil {
+		cfg.UDP = &UDPConfiguration{
+			Routers:  make(map[string]*UDPRouter),
+			Services: make(map[string]*UDPService),
+		}
 	}
 
-	jsonData, err := json.Marshal(config)
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+	cfg.UDP.Routers[group.RouterName] = &UDPRouter{
+		EntryPoints: group.EntryPoints,
+		Service:     group.ServiceName,
+	}
+
+	for name, backends := range split.loadBalancers {
+		servers := make([]UDPServer, 0, len(backends))
+		for _, backend := range backends {
+			servers = append(servers, UDPServer{Address: backend.Address})
+		}
+		cfg.UDP.Services[name] = &UDPService{
+			LoadBalancer: &UDPLoadBalancer{Servers: servers},
+		}
+	}
+	if split.weighted != nil {
+		cfg.UDP.Services[group.ServiceName] = &UDPService{Weighted: split.weighted}
+	}
+}
+
+// put sends a configuration to the REST provider, remembers it once accepted
+// and waits for Traefik to apply it. With merging enabled, the entries of
+// other writers are kept.
+func (b *Backend) put(ctx context.Context, jsonData []byte) error {
+	body := jsonData
+	if b.mergeUpdates {
+		merged, err := b.merge(ctx, jsonData)
+		if err != nil {
+			return err
+		}
+		body = merged
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", b.apiURL, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "PUT", b.apiURL, bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -115,10 +400,20 @@ func (b *Backend) updateBackendsInternal(ctx context.Context, backends []string)
 		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
 	}
 
-	slog.Info("Updated Traefik configuration",
-		"backend_count", len(backends),
-		"circuit_breaker_state", b.circuitBreaker.State())
+	b.mu.Lock()
+	b.priorPayload, b.lastPayload = b.lastPayload, jsonData
+	b.lastHash = [sha256.Size]byte{}
+	b.mu.Unlock()
+
+	// A mismatch fails the push, so the circuit breaker counts it
+	if err := b.verify(ctx, jsonData); err != nil {
+		return err
+	}
 
+	b.mu.Lock()
+	b.lastHash = sha256.Sum256(jsonData)
+	b.lastPushAt = time.Now()
+	b.mu.Unlock()
 	return nil
 }
 
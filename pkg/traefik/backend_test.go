@@ -0,0 +1,212 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+func TestUpdateBackendsUDP(t *testing.T) {
+	fake := &fakeTraefik{}
+	b := newTestBackend(t, fake,
+		config.Group{
+			Name:        "relay",
+			Protocol:    config.ProtocolTCP,
+			EntryPoints: []string{"tcp"},
+			Rule:        "HostSNI(`*`)",
+			RouterName:  "relay-router",
+			ServiceName: "relay-service",
+		},
+		config.Group{
+			Name:        "telemetry",
+			Protocol:    config.ProtocolUDP,
+			EntryPoints: []string{"telemetry"},
+			RouterName:  "telemetry-router",
+			ServiceName: "telemetry-service",
+		},
+	)
+	ctx := context.Background()
+
+	err := b.UpdateBackends(ctx, []discovery.Backend{
+		{Group: "relay", Address: "10.0.0.1:3333"},
+		{Group: "telemetry", Address: "10.0.0.1:8125", Weight: 3},
+		{Group: "telemetry", Address: "10.0.0.2:8125"},
+	})
+	if err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	// Decode what the stub REST provider received
+	fake.mu.Lock()
+	raw, _ := json.Marshal(fake.config)
+	fake.mu.Unlock()
+	var got Configuration
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("stub received invalid config: %v", err)
+	}
+
+	if got.TCP == nil || got.TCP.Routers["relay-router"] == nil {
+		t.Fatalf("tcp group missing from payload: %s", raw)
+	}
+	if got.UDP == nil {
+		t.Fatalf("udp section missing from payload: %s", raw)
+	}
+	router := got.UDP.Routers["telemetry-router"]
+	if router == nil || router.Service != "telemetry-service" || router.EntryPoints[0] != "telemetry" {
+		t.Errorf("udp router = %+v", router)
+	}
+	service := got.UDP.Services["telemetry-service"]
+	if service == nil || service.LoadBalancer == nil || len(service.LoadBalancer.Servers) != 2 {
+		t.Fatalf("udp service = %+v", service)
+	}
+	if service.LoadBalancer.Servers[0].Address != "10.0.0.1:8125" {
+		t.Errorf("udp server = %+v", service.LoadBalancer.Servers[0])
+	}
+
+	// The UDP entries take part in drift detection
+	drift, err := b.CheckDrift(ctx)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("CheckDrift() = %v, %v, want no drift", drift, err)
+	}
+	fake.restart()
+	drift, err = b.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 4 {
+		t.Errorf("drift after restart = %v, want tcp and udp routers and services missing", drift)
+	}
+}
+
+func TestUpdateBackendsSkipsUnchanged(t *testing.T) {
+	fake := &fakeTraefik{}
+	b := newTestBackend(t, fake)
+	ctx := context.Background()
+	puts := func() int {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return fake.putCount
+	}
+	one := []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}
+	two := []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.2:3333"}}
+	skipped := metrics.Get(MetricPushesSkipped)
+
+	for i, step := range []struct {
+		backends []discovery.Backend
+		wantPuts int
+	}{
+		{one, 1},
+		{one, 1}, // identical payload
+		{two, 2},
+		{two, 2},
+	} {
+		if err := b.UpdateBackends(ctx, step.backends); err != nil {
+			t.Fatalf("step %d: UpdateBackends() error = %v", i, err)
+		}
+		if got := puts(); got != step.wantPuts {
+			t.Errorf("step %d: PUTs = %d, want %d", i, got, step.wantPuts)
+		}
+	}
+	if got := metrics.Get(MetricPushesSkipped) - skipped; got != 2 {
+		t.Errorf("skipped pushes = %d, want 2", got)
+	}
+
+	// Drift invalidates the hash: Traefik no longer has what was pushed
+	fake.restart()
+	if drift, err := b.CheckDrift(ctx); err != nil || len(drift) == 0 {
+		t.Fatalf("CheckDrift() = %v, %v, want drift", drift, err)
+	}
+	if err := b.UpdateBackends(ctx, two); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := puts(); got != 3 {
+		t.Errorf("PUTs after drift = %d, want 3", got)
+	}
+
+	// An old push is repeated once the force interval passed
+	b.forceInterval = 10 * time.Millisecond
+	time.Sleep(20 * time.Millisecond)
+	if err := b.UpdateBackends(ctx, two); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := puts(); got != 4 {
+		t.Errorf("PUTs after force interval = %d, want 4", got)
+	}
+}
+
+func TestUpdateBackendsUnknownGroup(t *testing.T) {
+	fake := &fakeTraefik{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+	groups := []config.Group{tcpGroup("relay"), tcpGroup("telemetry")}
+
+	// A previous instance left both groups in Traefik
+	previous := New(testConfig(srv.URL, groups...))
+	if err := previous.UpdateBackends(ctx, []discovery.Backend{
+		{Group: "relay", Address: "10.0.0.1:3333"},
+		{Group: "telemetry", Address: "10.0.1.1:8125"},
+	}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	// Traefik reports service references qualified with the provider
+	fake.mu.Lock()
+	fake.rawdata["tcpRouters"].(map[string]any)["telemetry-router@rest"].(map[string]any)["service"] = "telemetry-service@rest"
+	fake.mu.Unlock()
+
+	telemetryServers := func() []string {
+		t.Helper()
+		fake.mu.Lock()
+		raw, _ := json.Marshal(fake.config)
+		fake.mu.Unlock()
+		var got Configuration
+		if err := json.Unmarshal(raw, &got); err != nil {
+			t.Fatalf("stub received invalid config: %v", err)
+		}
+		router := got.TCP.Routers["telemetry-router"]
+		service := got.TCP.Services["telemetry-service"]
+		if router == nil || service == nil {
+			return nil
+		}
+		if router.Service != "telemetry-service" {
+			t.Errorf("telemetry router service = %q", router.Service)
+		}
+		return tcpServerAddresses(service)
+	}
+
+	// After a restart the group without known backends keeps the live entries
+	b := New(testConfig(srv.URL, groups...))
+	for _, relay := range []string{"10.0.0.2:3333", "10.0.0.3:3333"} {
+		if err := b.UpdateBackends(ctx, []discovery.Backend{
+			{Group: "relay", Address: relay},
+			discovery.Placeholder("telemetry"),
+		}); err != nil {
+			t.Fatalf("UpdateBackends() error = %v", err)
+		}
+		if got := telemetryServers(); len(got) != 1 || got[0] != "10.0.1.1:8125" {
+			t.Errorf("telemetry servers = %v, want the previous one kept", got)
+		}
+	}
+	if drift, err := b.CheckDrift(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("CheckDrift() = %v, %v, want no drift", drift, err)
+	}
+
+	// A group Traefik doesn't have is left out instead of rendered empty
+	fake.restart()
+	fresh := New(testConfig(srv.URL, groups...))
+	if err := fresh.UpdateBackends(ctx, []discovery.Backend{
+		{Group: "relay", Address: "10.0.0.2:3333"},
+		discovery.Placeholder("telemetry"),
+	}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := telemetryServers(); got != nil {
+		t.Errorf("telemetry servers = %v, want the group left out", got)
+	}
+}
@@ -0,0 +1,159 @@
+package traefik
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// serviceSplit maps a group's backends onto Traefik services
+type serviceSplit struct {
+	// loadBalancers are the load balancer services to render, by service name
+	loadBalancers map[string][]discovery.Backend
+	// weighted, when set, is rendered as the group's service over the load balancers
+	weighted *WeightedRoundRobin
+}
+
+// splitServices renders a group without canary as a single load balancer. With
+// a canary, stable and canary pods get their own load balancers and the
+// group's service becomes a weighted service over both. A side without
+// backends gets no traffic, whatever the configured weight.
+func splitServices(group *config.Group, backends []discovery.Backend, canaryWeight int) serviceSplit {
+	if group.Canary == nil {
+		return serviceSplit{loadBalancers: map[string][]discovery.Backend{group.ServiceName: backends}}
+	}
+
+	var stable, canary []discovery.Backend
+	for _, backend := range backends {
+		if backend.Canary {
+			canary = append(canary, backend)
+		} else {
+			stable = append(stable, backend)
+		}
+	}
+
+	stableWeight := 100 - canaryWeight
+	switch {
+	case len(canary) == 0 && len(stable) > 0:
+		stableWeight, canaryWeight = 100, 0
+	case len(stable) == 0 && len(canary) > 0:
+		stableWeight, canaryWeight = 0, 100
+	}
+
+	// Weighted services need at least one child; zero weights are left out
+	weighted := &WeightedRoundRobin{}
+	if stableWeight > 0 || canaryWeight == 0 {
+		weighted.Services = append(weighted.Services, WeightedService{Name: group.StableServiceName(), Weight: &stableWeight})
+	}
+	if canaryWeight > 0 {
+		weighted.Services = append(weighted.Services, WeightedService{Name: group.CanaryServiceName(), Weight: &canaryWeight})
+	}
+
+	return serviceSplit{
+		loadBalancers: map[string][]discovery.Backend{
+			group.StableServiceName(): stable,
+			group.CanaryServiceName(): canary,
+		},
+		weighted: weighted,
+	}
+}
+
+// MetricCanaryWeight is the current canary percentage, labelled by group
+const MetricCanaryWeight = "canary_weight"
+
+// currentCanaryWeights returns the canary percentages to render
+func (b *Backend) currentCanaryWeights() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return maps.Clone(b.canaryWeights)
+}
+
+// setCanaryWeights replaces all canary weights with those of the fleet
+func (b *Backend) setCanaryWeights(weights map[string]int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.canaryWeights = maps.Clone(weights)
+}
+
+// CanaryWeights returns the current canary percentage of every group with a canary
+func (f *Fleet) CanaryWeights() map[string]int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return maps.Clone(f.canaryWeights)
+}
+
+// SetCanaryWeight changes the canary percentage of a group on every target.
+// It takes effect on the next push.
+func (f *Fleet) SetCanaryWeight(group string, weight int) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("canary weight must be between 0 and 100")
+	}
+
+	f.mu.Lock()
+	previous, ok := f.canaryWeights[group]
+	if ok {
+		f.canaryWeights[group] = weight
+		for _, t := range f.targets {
+			t.backend.setCanaryWeights(f.canaryWeights)
+		}
+	}
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("group %q has no canary", group)
+	}
+
+	metrics.SetLabeled(MetricCanaryWeight, group, int64(weight))
+	slog.Info("Canary weight changed",
+		"group", group,
+		"previous_weight", previous,
+		"weight", weight)
+	return nil
+}
+
+// CanaryHandler returns an HTTP handler to read (GET) and adjust (POST
+// ?group=<name>&weight=<percent>) canary weights. apply is called after a
+// change so it reaches Traefik without waiting for a pod change.
+func (f *Fleet) CanaryHandler(apply func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			weight, err := strconv.Atoi(r.URL.Query().Get("weight"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid weight: %w", err))
+				return
+			}
+			group := r.URL.Query().Get("group")
+			if group == "" {
+				group = config.DefaultGroupName
+			}
+			if err := f.SetCanaryWeight(group, weight); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			apply()
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(f.CanaryWeights())
+	})
+}
+
+// writeError sends a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
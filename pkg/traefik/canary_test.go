@@ -0,0 +1,179 @@
+package traefik
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+func canaryGroup(protocol string) config.Group {
+	group := config.Group{
+		Name:        "relay",
+		Protocol:    protocol,
+		EntryPoints: []string{"tcp"},
+		Rule:        "HostSNI(`*`)",
+		RouterName:  "relay-router",
+		ServiceName: "relay-service",
+		Canary:      &config.GroupCanary{PodLabels: "app=relay,track=canary", Weight: 10},
+	}
+	if protocol == config.ProtocolHTTP {
+		group.Rule = "PathPrefix(`/`)"
+	}
+	return group
+}
+
+// weights flattens a weighted service into child name -> weight
+func weights(w *WeightedRoundRobin) map[string]int {
+	got := make(map[string]int)
+	if w == nil {
+		return got
+	}
+	for _, s := range w.Services {
+		got[s.Name] = *s.Weight
+	}
+	return got
+}
+
+func TestRenderCanaryGroup(t *testing.T) {
+	stable := discovery.Backend{Group: "relay", Address: "10.0.0.1:3333"}
+	canary := discovery.Backend{Group: "relay", Address: "10.0.0.2:3333", Canary: true}
+
+	tests := []struct {
+		name     string
+		weight   int
+		backends []discovery.Backend
+		want     map[string]int
+	}{
+		{"split", 10, []discovery.Backend{stable, canary}, map[string]int{"relay-service-stable": 90, "relay-service-canary": 10}},
+		{"canary off", 0, []discovery.Backend{stable, canary}, map[string]int{"relay-service-stable": 100}},
+		{"full canary", 100, []discovery.Backend{stable, canary}, map[string]int{"relay-service-canary": 100}},
+		{"no canary pods", 50, []discovery.Backend{stable}, map[string]int{"relay-service-stable": 100}},
+		{"no stable pods", 10, []discovery.Backend{canary}, map[string]int{"relay-service-canary": 100}},
+		{"no pods", 10, nil, map[string]int{"relay-service-stable": 90, "relay-service-canary": 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBackend(t, &fakeTraefik{}, canaryGroup(config.ProtocolTCP))
+			b.setCanaryWeights(map[string]int{"relay": tt.weight})
+
+			cfg := b.buildConfiguration(tt.backends)
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if svc := cfg.TCP.Routers["relay-router"].Service; svc != "relay-service" {
+				t.Errorf("router service = %q, want relay-service", svc)
+			}
+			got := weights(cfg.TCP.Services["relay-service"].Weighted)
+			if len(got) != len(tt.want) {
+				t.Fatalf("weights = %v, want %v", got, tt.want)
+			}
+			for name, weight := range tt.want {
+				if got[name] != weight {
+					t.Errorf("weights = %v, want %v", got, tt.want)
+				}
+			}
+			stableLB := cfg.TCP.Services["relay-service-stable"].LoadBalancer
+			canaryLB := cfg.TCP.Services["relay-service-canary"].LoadBalancer
+			for _, server := range canaryLB.Servers {
+				if server.Address != canary.Address {
+					t.Errorf("canary service holds %s", server.Address)
+				}
+			}
+			for _, server := range stableLB.Servers {
+				if server.Address != stable.Address {
+					t.Errorf("stable service holds %s", server.Address)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderCanaryHTTPGroup(t *testing.T) {
+	b := newTestBackend(t, &fakeTraefik{}, canaryGroup(config.ProtocolHTTP))
+	cfg := b.buildConfiguration([]discovery.Backend{
+		{Group: "relay", Address: "10.0.0.1:8080"},
+		{Group: "relay", Address: "10.0.0.2:8080", Canary: true},
+	})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	got := weights(cfg.HTTP.Services["relay-service"].Weighted)
+	if got["relay-service-stable"] != 90 || got["relay-service-canary"] != 10 {
+		t.Errorf("weights = %v", got)
+	}
+	if urls := httpServerURLs(cfg.HTTP.Services["relay-service-canary"]); len(urls) != 1 || urls[0] != "http://10.0.0.2:8080" {
+		t.Errorf("canary servers = %v", urls)
+	}
+}
+
+// newCanaryFleet creates a fleet of one stub Traefik managing groups
+func newCanaryFleet(t *testing.T, groups ...config.Group) *Fleet {
+	t.Helper()
+	return NewFleet(testConfig("", groups...), NewTransport(nil), []string{newFleetTarget(t, &fakeTraefik{})})
+}
+
+func TestSetCanaryWeight(t *testing.T) {
+	b := newCanaryFleet(t, canaryGroup(config.ProtocolTCP), config.Group{
+		Name:        "other",
+		EntryPoints: []string{"tcp"},
+		Rule:        "HostSNI(`*`)",
+		RouterName:  "other-router",
+		ServiceName: "other-service",
+	})
+
+	if got := b.CanaryWeights(); len(got) != 1 || got["relay"] != 10 {
+		t.Fatalf("CanaryWeights() = %v, want the configured weight", got)
+	}
+	if err := b.SetCanaryWeight("relay", 101); err == nil {
+		t.Error("SetCanaryWeight(101) should fail")
+	}
+	if err := b.SetCanaryWeight("other", 10); err == nil {
+		t.Error("SetCanaryWeight() on a group without canary should fail")
+	}
+	if err := b.SetCanaryWeight("relay", 25); err != nil {
+		t.Fatalf("SetCanaryWeight() error = %v", err)
+	}
+	if got := b.CanaryWeights()["relay"]; got != 25 {
+		t.Errorf("weight = %d, want 25", got)
+	}
+	if got := metrics.GetLabeled(MetricCanaryWeight, "relay"); got != 25 {
+		t.Errorf("canary weight gauge = %d, want 25", got)
+	}
+}
+
+func TestCanaryHandler(t *testing.T) {
+	b := newCanaryFleet(t, canaryGroup(config.ProtocolTCP))
+	var applied int
+	handler := b.CanaryHandler(func() { applied++ })
+
+	tests := []struct {
+		method, target string
+		wantStatus     int
+		wantApplied    int
+		wantWeight     int
+	}{
+		{http.MethodGet, "/canary", http.StatusOK, 0, 10},
+		{http.MethodPost, "/canary?group=relay&weight=abc", http.StatusBadRequest, 0, 10},
+		{http.MethodPost, "/canary?group=missing&weight=5", http.StatusBadRequest, 0, 10},
+		{http.MethodPost, "/canary?group=relay&weight=30", http.StatusOK, 1, 30},
+		{http.MethodDelete, "/canary", http.StatusMethodNotAllowed, 1, 30},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, http.NoBody))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.wantStatus)
+		}
+		if applied != tt.wantApplied {
+			t.Errorf("%s %s applied = %d, want %d", tt.method, tt.target, applied, tt.wantApplied)
+		}
+		if got := b.CanaryWeights()["relay"]; got != tt.wantWeight {
+			t.Errorf("%s %s weight = %d, want %d", tt.method, tt.target, got, tt.wantWeight)
+		}
+	}
+}
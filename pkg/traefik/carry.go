@@ -0,0 +1,117 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+)
+
+// carryUnknownGroups replaces what was rendered for groups whose backends
+// aren't known yet with the entries Traefik already has: those of the last
+// applied payload or, before the first push, of the live REST provider
+// configuration, e.g. a restored snapshot. A group Traefik doesn't have yet
+// is left out rather than rendered without servers.
+func (b *Backend) carryUnknownGroups(ctx context.Context, cfg *Configuration, unknown map[string]bool) (*Configuration, error) {
+	rendered, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	doc, err := parseDocument(rendered)
+	if err != nil {
+		return nil, err
+	}
+	current, err := parseDocument(b.LastApplied())
+	if err != nil {
+		return nil, err
+	}
+	if len(current) == 0 {
+		if current, err = b.liveDocument(ctx); err != nil {
+			return nil, fmt.Errorf("failed to read configuration of groups with unknown backends: %w", err)
+		}
+	}
+
+	for i := range b.groups {
+		group := &b.groups[i]
+		if !unknown[group.Name] {
+			continue
+		}
+		// Groups without a protocol are rendered as TCP
+		protocol := group.Protocol
+		if protocol != config.ProtocolHTTP && protocol != config.ProtocolUDP {
+			protocol = config.ProtocolTCP
+		}
+		entries := [][2]string{
+			{"routers", group.RouterName},
+			{"services", group.ServiceName},
+			{"services", group.StableServiceName()},
+			{"services", group.CanaryServiceName()},
+		}
+		carried := 0
+		for _, entry := range entries {
+			kind, name := entry[0], entry[1]
+			delete(doc[protocol][kind], name)
+			if raw, ok := current.get(protocol, kind, name); ok {
+				doc.set(protocol, kind, name, raw)
+				carried++
+			}
+		}
+		slog.Warn("Backends of group unknown, keeping its current Traefik configuration",
+			"group", group.Name,
+			"carried_entries", carried)
+	}
+
+	merged, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	carried := &Configuration{}
+	if err := json.Unmarshal(merged, carried); err != nil {
+		return nil, fmt.Errorf("failed to parse carried config: %w", err)
+	}
+	carried.trimProviderSuffix()
+	return carried, nil
+}
+
+// trimProviderSuffix drops the @rest suffix Traefik adds to service
+// references in /api/rawdata, so carried entries match rendered ones
+func (c *Configuration) trimProviderSuffix() {
+	trim := func(name string) string {
+		return strings.TrimSuffix(name, restProviderSuffix)
+	}
+	trimWeighted := func(w *WeightedRoundRobin) {
+		if w == nil {
+			return
+		}
+		for i := range w.Services {
+			w.Services[i].Name = trim(w.Services[i].Name)
+		}
+	}
+	if c.HTTP != nil {
+		for _, r := range c.HTTP.Routers {
+			r.Service = trim(r.Service)
+		}
+		for _, s := range c.HTTP.Services {
+			trimWeighted(s.Weighted)
+		}
+	}
+	if c.TCP != nil {
+		for _, r := range c.TCP.Routers {
+			r.Service = trim(r.Service)
+		}
+		for _, s := range c.TCP.Services {
+			trimWeighted(s.Weighted)
+		}
+	}
+	if c.UDP != nil {
+		for _, r := range c.UDP.Routers {
+			r.Service = trim(r.Service)
+		}
+		for _, s := range c.UDP.Services {
+			trimWeighted(s.Weighted)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// restProviderSuffix is the provider suffix Traefik appends to REST provider names
+const restProviderSuffix = "@rest"
+
+// rawData is the subset of Traefik's /api/rawdata used for drift detection.
+// Entries are keyed by their provider-qualified name, e.g. relay-router@rest.
+type rawData struct {
+	HTTPRouters  map[string]*HTTPRouter  `json:"routers"`
+	HTTPServices map[string]*HTTPService `json:"services"`
+	TCPRouters   map[string]*TCPRouter   `json:"tcpRouters"`
+	TCPServices  map[string]*TCPService  `json:"tcpServices"`
+	UDPRouters   map[string]*UDPRouter   `json:"udpRouters"`
+	UDPServices  map[string]*UDPService  `json:"udpServices"`
+}
+
+// routerState is the comparable part of a router of any protocol
+type routerState struct {
+	Rule        string
+	Service     string
+	EntryPoints []string
+}
+
+// serviceState is the comparable part of a service of any protocol
+type serviceState struct {
+	Servers []string
+}
+
+// CheckDrift compares the last configuration Traefik accepted with what Traefik
+// currently serves and describes every difference. Nothing is reported before
+// the first successful push. Only routers, rules, entrypoints and server
+// addresses are compared, since Traefik doesn't echo every field back.
+func (b *Backend) CheckDrift(ctx context.Context) ([]string, error) {
+	payload := b.LastApplied()
+	if payload == nil {
+		return nil, nil
+	}
+
+	var desired Configuration
+	if err := json.Unmarshal(payload, &desired); err != nil {
+		return nil, fmt.Errorf("failed to parse applied config: %w", err)
+	}
+
+	live, err := b.fetchRawData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	wantRouters, wantServices := desiredState(&desired)
+	gotRouters, gotServices := live.state()
+
+	var drift []string
+	for name, want := range wantRouters {
+		got, ok := gotRouters[name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("router %s is missing", name))
+			continue
+		}
+		if got.Rule != want.Rule {
+			drift = append(drift, fmt.Sprintf("router %s rule is %q, want %q", name, got.Rule, want.Rule))
+		}
+		if got.Service != want.Service {
+			drift = append(drift, fmt.Sprintf("router %s service is %q, want %q", name, got.Service, want.Service))
+		}
+		if !sameSet(got.EntryPoints, want.EntryPoints) {
+			drift = append(drift, fmt.Sprintf("router %s entrypoints are %v, want %v", name, got.EntryPoints, want.EntryPoints))
+		}
+	}
+	for name, want := range wantServices {
+		got, ok := gotServices[name]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("service %s is missing", name))
+			continue
+		}
+		if !sameSet(got.Servers, want.Servers) {
+			drift = append(drift, fmt.Sprintf("service %s servers are %v, want %v", name, got.Servers, want.Servers))
+		}
+	}
+	slices.Sort(drift)
+	if len(drift) > 0 {
+		// The next update must push again even though the payload is unchanged
+		b.forgetApplied()
+	}
+	return drift, nil
+}
+
+// desiredState flattens a configuration into comparable state keyed by protocol/name
+func desiredState(cfg *Configuration) (map[string]routerState, map[string]serviceState) {
+	routers := make(map[string]routerState)
+	services := make(map[string]serviceState)
+	if cfg.HTTP != nil {
+		for name, r := range cfg.HTTP.Routers {
+			routers[protocolKey("http", name)] = routerState{r.Rule, r.Service, r.EntryPoints}
+		}
+		for name, s := range cfg.HTTP.Services {
+			services[protocolKey("http", name)] = serviceState{httpServerURLs(s)}
+		}
+	}
+	if cfg.TCP != nil {
+		for name, r := range cfg.TCP.Routers {
+			routers[protocolKey("tcp", name)] = routerState{r.Rule, r.Service, r.EntryPoints}
+		}
+		for name, s := range cfg.TCP.Services {
+			services[protocolKey("tcp", name)] = serviceState{tcpServerAddresses(s)}
+		}
+	}
+	if cfg.UDP != nil {
+		for name, r := range cfg.UDP.Routers {
+			routers[protocolKey("udp", name)] = routerState{"", r.Service, r.EntryPoints}
+		}
+		for name, s := range cfg.UDP.Services {
+			services[protocolKey("udp", name)] = serviceState{udpServerAddresses(s)}
+		}
+	}
+	return routers, services
+}
+
+// state flattens the live REST provider entries into comparable state keyed by protocol/name
+func (d *rawData) state() (map[string]routerState, map[string]serviceState) {
+	routers := make(map[string]routerState)
+	services := make(map[string]serviceState)
+	for qualified, r := range d.HTTPRouters {
+		if name, ok := strings.CutSuffix(qualified, restProviderSuffix); ok {
+			routers[protocolKey("http", name)] = routerState{r.Rule, strings.TrimSuffix(r.Service, restProviderSuffix), r.EntryPoints}
+		}
+	}
+	for qualified, s := range d.HTTPServices {
+		if name, ok := strings.CutSuffix(qualified, restProviderSuffix); ok {
+			services[protocolKey("http", name)] = serviceState{httpServerURLs(s)}
+		}
+	}
+	for qualified, r := range d.TCPRouters {
+		if name, ok := strings.CutSuffix(qualified, restProviderSuffix); ok {
+			routers[protocolKey("tcp", name)] = routerState{r.Rule, strings.TrimSuffix(r.Service, restProviderSuffix), r.EntryPoints}
+		}
+	}
+	for qualified, s := range d.TCPServices {
+		if name, ok := strings.CutSuffix(qualified, restProviderSuffix); ok {
+			services[protocolKey("tcp", name)] = serviceState{tcpServerAddresses(s)}
+		}
+	}
+	for qualified, r := range d.UDPRouters {
+		if name, ok := strings.CutSuffix(qualified, restProviderSuffix); ok {
+			routers[protocolKey("udp", name)] = routerState{"", strings.TrimSuffix(r.Service, restProviderSuffix), r.EntryPoints}
+		}
+	}
+	for qualified, s := range d.UDPServices {
+		if name, ok := strings.CutSuffix(qualified, restProviderSuffix); ok {
+			services[protocolKey("udp", name)] = serviceState{udpServerAddresses(s)}
+		}
+	}
+	return routers, services
+}
+
+// protocolKey names a router or service unambiguously across protocols
+func protocolKey(protocol, name string) string {
+	return protocol + "/" + name
+}
+
+// fetchRawData reads the live dynamic configuration from the Traefik API
+func (b *Backend) fetchRawData(ctx context.Context) (*rawData, error) {
+	var live rawData
+	if err := b.getRawData(ctx, &live); err != nil {
+		return nil, err
+	}
+	return &live, nil
+}
+
+// getRawData decodes Traefik's /api/rawdata into v
+func (b *Backend) getRawData(ctx context.Context, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", b.rawDataURL, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("failed to create rawdata request: %w", err)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch rawdata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rawdata returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode rawdata: %w", err)
+	}
+	return nil
+}
+
+// rawDataURL derives the /api/rawdata endpoint from the REST provider URL,
+// e.g. http://127.0.0.1:8080/api/providers/rest -> http://127.0.0.1:8080/api/rawdata
+func rawDataURL(apiURL string) string {
+	return apiEndpoint(apiURL, "/rawdata")
+}
+
+// apiEndpoint derives an endpoint of the Traefik API from the REST provider URL
+func apiEndpoint(apiURL, path string) string {
+	if base, ok := strings.CutSuffix(strings.TrimSuffix(apiURL, "/"), "/providers/rest"); ok {
+		return base + path
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return apiURL
+	}
+	u.Path = "/api" + path
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String()
+}
+
+// tcpServerAddresses lists the server addresses of a TCP service
+func tcpServerAddresses(s *TCPService) []string {
+	if s == nil || s.LoadBalancer == nil {
+		return nil
+	}
+	addresses := make([]string, 0, len(s.LoadBalancer.Servers))
+	for _, server := range s.LoadBalancer.Servers {
+		addresses = append(addresses, server.Address)
+	}
+	return addresses
+}
+
+// udpServerAddresses lists the server addresses of a UDP service
+func udpServerAddresses(s *UDPService) []string {
+	if s == nil || s.LoadBalancer == nil {
+		return nil
+	}
+	addresses := make([]string, 0, len(s.LoadBalancer.Servers))
+	for _, server := range s.LoadBalancer.Servers {
+		addresses = append(addresses, server.Address)
+	}
+	return addresses
+}
+
+// httpServerURLs lists the server URLs of an HTTP service
+func httpServerURLs(s *HTTPService) []string {
+	if s == nil || s.LoadBalancer == nil {
+		return nil
+	}
+	urls := make([]string, 0, len(s.LoadBalancer.Servers))
+	for _, server := range s.LoadBalancer.Servers {
+		urls = append(urls, server.URL)
+	}
+	return urls
+}
+
+// serverList lists the server addresses or URLs of a service entry of any protocol
+func serverList(protocol string, entry json.RawMessage) ([]string, error) {
+	switch protocol {
+	case "http":
+		var s HTTPService
+		err := json.Unmarshal(entry, &s)
+		return httpServerURLs(&s), err
+	case "udp":
+		var s UDPService
+		err := json.Unmarshal(entry, &s)
+		return udpServerAddresses(&s), err
+	default:
+		var s TCPService
+		err := json.Unmarshal(entry, &s)
+		return tcpServerAddresses(&s), err
+	}
+}
+
+// sameSet reports whether a and b hold the same strings in any order
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
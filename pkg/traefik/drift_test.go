@@ -0,0 +1,236 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+// fakeTraefik is a REST provider that reports what it was sent through /api/rawdata,
+// the way Traefik does: names suffixed with @rest
+type fakeTraefik struct {
+	mu       sync.Mutex
+	config   map[string]any
+	rawdata  map[string]any
+	putCount int
+	// disabled names entries reported with status "disabled" instead of "enabled"
+	disabled map[string]bool
+	// applyDelay hides pushed entries from the per-entry API for a while,
+	// like Traefik's asynchronous reload
+	applyDelay time.Duration
+	appliedAt  time.Time
+}
+
+func (f *fakeTraefik) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/api/providers/rest":
+		var cfg map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.putCount++
+		f.appliedAt = time.Now().Add(f.applyDelay)
+		f.config = cfg
+		f.rawdata = map[string]any{}
+		for protocol, prefix := range map[string]string{"http": "", "tcp": "tcp", "udp": "udp"} {
+			section, _ := cfg[protocol].(map[string]any)
+			for _, kind := range []string{"routers", "services"} {
+				key := kind
+				if prefix != "" {
+					key = prefix + strings.ToUpper(kind[:1]) + kind[1:]
+				}
+				entries := map[string]any{}
+				items, _ := section[kind].(map[string]any)
+				for name, item := range items {
+					entries[name+"@rest"] = item
+				}
+				f.rawdata[key] = entries
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/rawdata":
+		_ = json.NewEncoder(w).Encode(f.rawdata)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/"):
+		// /api/<protocol>/<kind>/<name>@rest, e.g. /api/tcp/services/relay-service@rest
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+		if len(parts) != 3 || time.Now().Before(f.appliedAt) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		key := parts[1]
+		if parts[0] != "http" {
+			key = parts[0] + strings.ToUpper(key[:1]) + key[1:]
+		}
+		entries, _ := f.rawdata[key].(map[string]any)
+		entry, ok := entries[parts[2]].(map[string]any)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		status := map[string]any{"status": "enabled"}
+		if f.disabled[strings.TrimSuffix(parts[2], "@rest")] {
+			status = map[string]any{"status": "disabled", "error": []string{"invalid rule"}}
+		}
+		for k, v := range entry {
+			status[k] = v
+		}
+		_ = json.NewEncoder(w).Encode(status)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// restart forgets everything, like a restarted Traefik
+func (f *fakeTraefik) restart() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.config = nil
+	f.rawdata = map[string]any{}
+}
+
+func newTestBackend(t *testing.T, handler http.Handler, groups ...config.Group) *Backend {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return New(testConfig(srv.URL, groups...))
+}
+
+// testConfig is a minimal configuration for a backend talking to the stub at url
+func testConfig(url string, groups ...config.Group) *config.Config {
+	return &config.Config{
+		Groups:                groups,
+		TraefikAPIURL:         url + "/api/providers/rest",
+		PodLabels:             "app=test",
+		PodNamespace:          "default",
+		PodNamespaces:         []string{"default"},
+		BackendPort:           3333,
+		LoadBalancerMethod:    "leastconn",
+		RouterName:            "relay-router",
+		ServiceName:           "relay-service",
+		CBMaxRequests:         5,
+		CBInterval:            time.Minute,
+		CBTimeout:             30 * time.Second,
+		CBConsecutiveFailures: 5,
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	fake := &fakeTraefik{}
+	b := newTestBackend(t, fake)
+	ctx := context.Background()
+
+	// Nothing applied yet, nothing to compare
+	drift, err := b.CheckDrift(ctx)
+	if err != nil || drift != nil {
+		t.Fatalf("CheckDrift() before first push = %v, %v", drift, err)
+	}
+
+	backends := []discovery.Backend{
+		{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"},
+		{Group: config.DefaultGroupName, Address: "10.0.0.2:3333"},
+	}
+	if err := b.UpdateBackends(ctx, backends); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	drift, err = b.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 0 {
+		t.Errorf("unexpected drift right after push: %v", drift)
+	}
+
+	fake.restart()
+	drift, err = b.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 2 {
+		t.Errorf("drift after restart = %v, want missing router and service", drift)
+	}
+}
+
+func TestCheckDriftServers(t *testing.T) {
+	fake := &fakeTraefik{}
+	b := newTestBackend(t, fake)
+	ctx := context.Background()
+
+	if err := b.UpdateBackends(ctx, []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	// Someone else overwrote the servers
+	fake.mu.Lock()
+	fake.rawdata["tcpServices"] = map[string]any{
+		"relay-service@rest": map[string]any{
+			"loadBalancer": map[string]any{
+				"servers": []any{map[string]any{"address": "10.9.9.9:3333"}},
+			},
+		},
+	}
+	fake.mu.Unlock()
+
+	drift, err := b.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 1 || !strings.Contains(drift[0], "servers") {
+		t.Errorf("drift = %v, want a server difference", drift)
+	}
+}
+
+func TestRawDataURL(t *testing.T) {
+	tests := map[string]string{
+		"http://127.0.0.1:8080/api/providers/rest":  "http://127.0.0.1:8080/api/rawdata",
+		"http://127.0.0.1:8080/api/providers/rest/": "http://127.0.0.1:8080/api/rawdata",
+		"http://traefik:9000/custom":                "http://traefik:9000/api/rawdata",
+	}
+	for in, want := range tests {
+		if got := rawDataURL(in); got != want {
+			t.Errorf("rawDataURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCheckDriftHTTP(t *testing.T) {
+	fake := &fakeTraefik{}
+	b := newTestBackend(t, fake, config.Group{
+		Name:        "api",
+		Protocol:    config.ProtocolHTTP,
+		EntryPoints: []string{"web"},
+		Rule:        "Host(`api.internal`)",
+		RouterName:  "api-router",
+		ServiceName: "api-service",
+	})
+	ctx := context.Background()
+
+	if err := b.UpdateBackends(ctx, []discovery.Backend{{Group: "api", Address: "10.0.0.1:8080"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	drift, err := b.CheckDrift(ctx)
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("CheckDrift() = %v, %v, want no drift", drift, err)
+	}
+
+	fake.restart()
+	drift, err = b.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 2 {
+		t.Errorf("drift after restart = %v, want missing router and service", drift)
+	}
+}
@@ -0,0 +1,430 @@
+package traefik
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Configuration is the dynamic configuration accepted by Traefik's REST provider.
+// Only the options this project sets or may set are modelled; names follow
+// Traefik's dynamic configuration reference.
+type Configuration struct {
+	HTTP *HTTPConfiguration `json:"http,omitempty"`
+	TCP  *TCPConfiguration  `json:"tcp,omitempty"`
+	UDP  *UDPConfiguration  `json:"udp,omitempty"`
+}
+
+// HTTPConfiguration holds the HTTP routers, services, middlewares and transports
+type HTTPConfiguration struct {
+	Routers           map[string]*HTTPRouter       `json:"routers,omitempty"`
+	Services          map[string]*HTTPService      `json:"services,omitempty"`
+	Middlewares       map[string]*HTTPMiddleware   `json:"middlewares,omitempty"`
+	ServersTransports map[string]*ServersTransport `json:"serversTransports,omitempty"`
+}
+
+// HTTPRouter routes matching HTTP requests to a service
+type HTTPRouter struct {
+	EntryPoints []string         `json:"entryPoints,omitempty"`
+	Middlewares []string         `json:"middlewares,omitempty"`
+	Service     string           `json:"service"`
+	Rule        string           `json:"rule"`
+	Priority    int              `json:"priority,omitempty"`
+	TLS         *RouterTLSConfig `json:"tls,omitempty"`
+}
+
+// RouterTLSConfig enables TLS termination on an HTTP router
+type RouterTLSConfig struct {
+	Options      string   `json:"options,omitempty"`
+	CertResolver string   `json:"certResolver,omitempty"`
+	Domains      []Domain `json:"domains,omitempty"`
+}
+
+// Domain is a certificate domain with optional SANs
+type Domain struct {
+	Main string   `json:"main,omitempty"`
+	SANs []string `json:"sans,omitempty"`
+}
+
+// HTTPService is either a load balancer over servers or a weighted set of services
+type HTTPService struct {
+	LoadBalancer *HTTPLoadBalancer   `json:"loadBalancer,omitempty"`
+	Weighted     *WeightedRoundRobin `json:"weighted,omitempty"`
+}
+
+// HTTPLoadBalancer balances requests over HTTP servers
+type HTTPLoadBalancer struct {
+	Servers          []HTTPServer     `json:"servers"`
+	PassHostHeader   *bool            `json:"passHostHeader,omitempty"`
+	HealthCheck      *HTTPHealthCheck `json:"healthCheck,omitempty"`
+	ServersTransport string           `json:"serversTransport,omitempty"`
+}
+
+// HTTPServer is an HTTP backend
+type HTTPServer struct {
+	URL    string `json:"url"`
+	Weight *int   `json:"weight,omitempty"`
+}
+
+// HTTPHealthCheck is an active health check of HTTP servers
+type HTTPHealthCheck struct {
+	Path     string `json:"path,omitempty"`
+	Interval string `json:"interval,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+}
+
+// WeightedRoundRobin splits traffic between services by weight
+type WeightedRoundRobin struct {
+	Services []WeightedService `json:"services"`
+}
+
+// WeightedService is a service taking part in a weighted round robin
+type WeightedService struct {
+	Name   string `json:"name"`
+	Weight *int   `json:"weight,omitempty"`
+}
+
+// HTTPMiddleware configures exactly one HTTP middleware
+type HTTPMiddleware struct {
+	Headers     *Headers     `json:"headers,omitempty"`
+	IPAllowList *IPAllowList `json:"ipAllowList,omitempty"`
+	StripPrefix *StripPrefix `json:"stripPrefix,omitempty"`
+	RateLimit   *RateLimit   `json:"rateLimit,omitempty"`
+	InFlightReq *InFlightReq `json:"inFlightReq,omitempty"`
+}
+
+// Headers adds or removes request and response headers
+type Headers struct {
+	CustomRequestHeaders  map[string]string `json:"customRequestHeaders,omitempty"`
+	CustomResponseHeaders map[string]string `json:"customResponseHeaders,omitempty"`
+}
+
+// IPAllowList only admits clients from the given ranges
+type IPAllowList struct {
+	SourceRange []string `json:"sourceRange"`
+}
+
+// StripPrefix removes path prefixes before forwarding
+type StripPrefix struct {
+	Prefixes []string `json:"prefixes"`
+}
+
+// RateLimit limits the request rate per source
+type RateLimit struct {
+	Average int64  `json:"average,omitempty"`
+	Period  string `json:"period,omitempty"`
+	Burst   int64  `json:"burst,omitempty"`
+}
+
+// InFlightReq limits simultaneous in-flight requests
+type InFlightReq struct {
+	Amount int64 `json:"amount"`
+}
+
+// ServersTransport configures the connections from Traefik to HTTP servers
+type ServersTransport struct {
+	ServerName          string   `json:"serverName,omitempty"`
+	InsecureSkipVerify  bool     `json:"insecureSkipVerify,omitempty"`
+	RootCAs             []string `json:"rootCAs,omitempty"`
+	MaxIdleConnsPerHost int      `json:"maxIdleConnsPerHost,omitempty"`
+}
+
+// TCPConfiguration holds the TCP routers, services, middlewares and transports
+type TCPConfiguration struct {
+	Routers           map[string]*TCPRouter           `json:"routers,omitempty"`
+	Services          map[string]*TCPService          `json:"services,omitempty"`
+	Middlewares       map[string]*TCPMiddleware       `json:"middlewares,omitempty"`
+	ServersTransports map[string]*TCPServersTransport `json:"serversTransports,omitempty"`
+}
+
+// TCPRouter routes matching TCP connections to a service
+type TCPRouter struct {
+	EntryPoints []string            `json:"entryPoints,omitempty"`
+	Middlewares []string            `json:"middlewares,omitempty"`
+	Service     string              `json:"service"`
+	Rule        string              `json:"rule"`
+	Priority    int                 `json:"priority,omitempty"`
+	TLS         *RouterTCPTLSConfig `json:"tls,omitempty"`
+}
+
+// RouterTCPTLSConfig enables TLS on a TCP router, either terminated or passed through
+type RouterTCPTLSConfig struct {
+	Passthrough  bool     `json:"passthrough,omitempty"`
+	Options      string   `json:"options,omitempty"`
+	CertResolver string   `json:"certResolver,omitempty"`
+	Domains      []Domain `json:"domains,omitempty"`
+}
+
+// TCPService is either a load balancer over servers or a weighted set of services
+type TCPService struct {
+	LoadBalancer *TCPLoadBalancer    `json:"loadBalancer,omitempty"`
+	Weighted     *WeightedRoundRobin `json:"weighted,omitempty"`
+}
+
+// TCPLoadBalancer balances connections over TCP servers
+type TCPLoadBalancer struct {
+	// Method is the configured LB_METHOD. Traefik's TCP load balancer has no
+	// such option, so it is never sent.
+	Method           string         `json:"-"`
+	Servers          []TCPServer    `json:"servers"`
+	ProxyProtocol    *ProxyProtocol `json:"proxyProtocol,omitempty"`
+	ServersTransport string         `json:"serversTransport,omitempty"`
+}
+
+// TCPServer is a TCP backend
+type TCPServer struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"`
+	TLS     bool   `json:"tls,omitempty"`
+}
+
+// ProxyProtocol sends a PROXY protocol header to the servers
+type ProxyProtocol struct {
+	Version int `json:"version,omitempty"`
+}
+
+// TCPMiddleware configures exactly one TCP middleware
+type TCPMiddleware struct {
+	IPAllowList  *IPAllowList  `json:"ipAllowList,omitempty"`
+	InFlightConn *InFlightConn `json:"inFlightConn,omitempty"`
+}
+
+// InFlightConn limits simultaneous connections per source IP
+type InFlightConn struct {
+	Amount int64 `json:"amount"`
+}
+
+// TCPServersTransport configures the connections from Traefik to TCP servers
+type TCPServersTransport struct {
+	DialTimeout   string           `json:"dialTimeout,omitempty"`
+	DialKeepAlive string           `json:"dialKeepAlive,omitempty"`
+	TLS           *TLSClientConfig `json:"tls,omitempty"`
+}
+
+// TLSClientConfig configures TLS from Traefik to servers
+type TLSClientConfig struct {
+	ServerName         string   `json:"serverName,omitempty"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify,omitempty"`
+	RootCAs            []string `json:"rootCAs,omitempty"`
+}
+
+// UDPConfiguration holds the UDP routers and services
+type UDPConfiguration struct {
+	Routers  map[string]*UDPRouter  `json:"routers,omitempty"`
+	Services map[string]*UDPService `json:"services,omitempty"`
+}
+
+// UDPRouter forwards datagrams from its entrypoints to a service
+type UDPRouter struct {
+	EntryPoints []string `json:"entryPoints,omitempty"`
+	Service     string   `json:"service"`
+}
+
+// UDPService is either a load balancer over servers or a weighted set of services
+type UDPService struct {
+	LoadBalancer *UDPLoadBalancer    `json:"loadBalancer,omitempty"`
+	Weighted     *WeightedRoundRobin `json:"weighted,omitempty"`
+}
+
+// UDPLoadBalancer balances datagrams over UDP servers
+type UDPLoadBalancer struct {
+	Servers []UDPServer `json:"servers"`
+}
+
+// UDPServer is a UDP backend
+type UDPServer struct {
+	Address string `json:"address"`
+}
+
+// Validate checks that routers reference existing services and middlewares and
+// that every service and middleware is well formed. References to other
+// providers (names containing "@") can't be checked and are accepted.
+func (c *Configuration) Validate() error {
+	var errs []error
+	if c.HTTP != nil {
+		errs = append(errs, c.HTTP.validate()...)
+	}
+	if c.TCP != nil {
+		errs = append(errs, c.TCP.validate()...)
+	}
+	if c.UDP != nil {
+		errs = append(errs, c.UDP.validate()...)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *HTTPConfiguration) validate() []error {
+	var errs []error
+	for _, name := range sortedKeys(c.Routers) {
+		r := c.Routers[name]
+		if r.Rule == "" {
+			errs = append(errs, fmt.Errorf("http router %s: rule is required", name))
+		}
+		if !resolves(r.Service, c.Services) {
+			errs = append(errs, fmt.Errorf("http router %s: unknown service %q", name, r.Service))
+		}
+		for _, m := range r.Middlewares {
+			if !resolves(m, c.Middlewares) {
+				errs = append(errs, fmt.Errorf("http router %s: unknown middleware %q", name, m))
+			}
+		}
+	}
+	for _, name := range sortedKeys(c.Services) {
+		s := c.Services[name]
+		switch {
+		case (s.LoadBalancer == nil) == (s.Weighted == nil):
+			errs = append(errs, fmt.Errorf("http service %s: exactly one of loadBalancer and weighted is required", name))
+		case s.LoadBalancer != nil:
+			for i, server := range s.LoadBalancer.Servers {
+				if server.URL == "" {
+					errs = append(errs, fmt.Errorf("http service %s: server %d has no url", name, i))
+				}
+			}
+			if t := s.LoadBalancer.ServersTransport; t != "" && !resolves(t, c.ServersTransports) {
+				errs = append(errs, fmt.Errorf("http service %s: unknown serversTransport %q", name, t))
+			}
+		default:
+			errs = append(errs, validateWeighted("http", name, s.Weighted, func(ref string) bool {
+				return resolves(ref, c.Services)
+			})...)
+		}
+	}
+	for _, name := range sortedKeys(c.Middlewares) {
+		if n := countSet(c.Middlewares[name].Headers != nil, c.Middlewares[name].IPAllowList != nil,
+			c.Middlewares[name].StripPrefix != nil, c.Middlewares[name].RateLimit != nil,
+			c.Middlewares[name].InFlightReq != nil); n != 1 {
+			errs = append(errs, fmt.Errorf("http middleware %s: exactly one middleware type is required, got %d", name, n))
+		}
+	}
+	return errs
+}
+
+func (c *TCPConfiguration) validate() []error {
+	var errs []error
+	for _, name := range sortedKeys(c.Routers) {
+		r := c.Routers[name]
+		if r.Rule == "" {
+			errs = append(errs, fmt.Errorf("tcp router %s: rule is required", name))
+		}
+		if !resolves(r.Service, c.Services) {
+			errs = append(errs, fmt.Errorf("tcp router %s: unknown service %q", name, r.Service))
+		}
+		for _, m := range r.Middlewares {
+			if !resolves(m, c.Middlewares) {
+				errs = append(errs, fmt.Errorf("tcp router %s: unknown middleware %q", name, m))
+			}
+		}
+	}
+	for _, name := range sortedKeys(c.Services) {
+		s := c.Services[name]
+		switch {
+		case (s.LoadBalancer == nil) == (s.Weighted == nil):
+			errs = append(errs, fmt.Errorf("tcp service %s: exactly one of loadBalancer and weighted is required", name))
+		case s.LoadBalancer != nil:
+			for i, server := range s.LoadBalancer.Servers {
+				if server.Address == "" {
+					errs = append(errs, fmt.Errorf("tcp service %s: server %d has no address", name, i))
+				}
+				if server.Weight < 0 {
+					errs = append(errs, fmt.Errorf("tcp service %s: server %d has a negative weight", name, i))
+				}
+			}
+			if pp := s.LoadBalancer.ProxyProtocol; pp != nil && pp.Version != 0 && pp.Version != 1 && pp.Version != 2 {
+				errs = append(errs, fmt.Errorf("tcp service %s: proxy protocol version must be 1 or 2", name))
+			}
+			if t := s.LoadBalancer.ServersTransport; t != "" && !resolves(t, c.ServersTransports) {
+				errs = append(errs, fmt.Errorf("tcp service %s: unknown serversTransport %q", name, t))
+			}
+		default:
+			errs = append(errs, validateWeighted("tcp", name, s.Weighted, func(ref string) bool {
+				return resolves(ref, c.Services)
+			})...)
+		}
+	}
+	for _, name := range sortedKeys(c.Middlewares) {
+		if n := countSet(c.Middlewares[name].IPAllowList != nil, c.Middlewares[name].InFlightConn != nil); n != 1 {
+			errs = append(errs, fmt.Errorf("tcp middleware %s: exactly one middleware type is required, got %d", name, n))
+		}
+	}
+	return errs
+}
+
+func (c *UDPConfiguration) validate() []error {
+	var errs []error
+	for _, name := range sortedKeys(c.Routers) {
+		if r := c.Routers[name]; !resolves(r.Service, c.Services) {
+			errs = append(errs, fmt.Errorf("udp router %s: unknown service %q", name, r.Service))
+		}
+	}
+	for _, name := range sortedKeys(c.Services) {
+		s := c.Services[name]
+		switch {
+		case (s.LoadBalancer == nil) == (s.Weighted == nil):
+			errs = append(errs, fmt.Errorf("udp service %s: exactly one of loadBalancer and weighted is required", name))
+		case s.LoadBalancer != nil:
+			for i, server := range s.LoadBalancer.Servers {
+				if server.Address == "" {
+					errs = append(errs, fmt.Errorf("udp service %s: server %d has no address", name, i))
+				}
+			}
+		default:
+			errs = append(errs, validateWeighted("udp", name, s.Weighted, func(ref string) bool {
+				return resolves(ref, c.Services)
+			})...)
+		}
+	}
+	return errs
+}
+
+// validateWeighted checks the services of a weighted round robin
+func validateWeighted(protocol, name string, w *WeightedRoundRobin, exists func(string) bool) []error {
+	var errs []error
+	if len(w.Services) == 0 {
+		errs = append(errs, fmt.Errorf("%s service %s: weighted needs at least one service", protocol, name))
+	}
+	for _, ws := range w.Services {
+		if ws.Name == name {
+			errs = append(errs, fmt.Errorf("%s service %s: weighted service references itself", protocol, name))
+		} else if !exists(ws.Name) {
+			errs = append(errs, fmt.Errorf("%s service %s: unknown weighted service %q", protocol, name, ws.Name))
+		}
+		if ws.Weight != nil && *ws.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s service %s: weight of %q must not be negative", protocol, name, ws.Name))
+		}
+	}
+	return errs
+}
+
+// resolves reports whether ref names an entry of m, or lives in another provider
+func resolves[V any](ref string, m map[string]V) bool {
+	if ref == "" {
+		return false
+	}
+	if strings.Contains(ref, "@") {
+		return true
+	}
+	_, ok := m[ref]
+	return ok
+}
+
+// countSet counts the true values
+func countSet(set ...bool) int {
+	n := 0
+	for _, s := range set {
+		if s {
+			n++
+		}
+	}
+	return n
+}
+
+// sortedKeys returns the keys of m in order, for deterministic error lists
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,342 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+func intPtr(v int) *int { return &v }
+
+func boolPtr(v bool) *bool { return &v }
+
+// fullConfiguration sets every modelled option at least once
+func fullConfiguration() *Configuration {
+	return &Configuration{
+		HTTP: &HTTPConfiguration{
+			Routers: map[string]*HTTPRouter{
+				"web": {
+					EntryPoints: []string{"websecure"},
+					Middlewares: []string{"strip", "allow"},
+					Service:     "web-canary",
+					Rule:        "Host(`example.com`)",
+					Priority:    10,
+					TLS: &RouterTLSConfig{
+						Options:      "modern",
+						CertResolver: "le",
+						Domains:      []Domain{{Main: "example.com", SANs: []string{"www.example.com"}}},
+					},
+				},
+			},
+			Services: map[string]*HTTPService{
+				"web": {LoadBalancer: &HTTPLoadBalancer{
+					Servers:          []HTTPServer{{URL: "http://10.0.0.1:8080", Weight: intPtr(2)}},
+					PassHostHeader:   boolPtr(false),
+					HealthCheck:      &HTTPHealthCheck{Path: "/healthz", Interval: "10s", Timeout: "3s"},
+					ServersTransport: "internal",
+				}},
+				"web-next": {LoadBalancer: &HTTPLoadBalancer{
+					Servers: []HTTPServer{{URL: "http://10.0.0.2:8080"}},
+				}},
+				"web-canary": {Weighted: &WeightedRoundRobin{Services: []WeightedService{
+					{Name: "web", Weight: intPtr(90)},
+					{Name: "web-next", Weight: intPtr(10)},
+				}}},
+			},
+			Middlewares: map[string]*HTTPMiddleware{
+				"strip":   {StripPrefix: &StripPrefix{Prefixes: []string{"/api"}}},
+				"allow":   {IPAllowList: &IPAllowList{SourceRange: []string{"10.0.0.0/8"}}},
+				"headers": {Headers: &Headers{CustomRequestHeaders: map[string]string{"X-Via": "ilb"}}},
+				"limit":   {RateLimit: &RateLimit{Average: 100, Period: "1s", Burst: 50}},
+				"flight":  {InFlightReq: &InFlightReq{Amount: 10}},
+			},
+			ServersTransports: map[string]*ServersTransport{
+				"internal": {ServerName: "web.internal", RootCAs: []string{"/ca.pem"}, MaxIdleConnsPerHost: 4},
+			},
+		},
+		TCP: &TCPConfiguration{
+			Routers: map[string]*TCPRouter{
+				"relay": {
+					EntryPoints: []string{"tcp"},
+					Middlewares: []string{"conns"},
+					Service:     "relay",
+					Rule:        "HostSNI(`relay.example.com`)",
+					Priority:    5,
+					TLS:         &RouterTCPTLSConfig{Passthrough: true},
+				},
+			},
+			Services: map[string]*TCPService{
+				"relay": {LoadBalancer: &TCPLoadBalancer{
+					Servers:          []TCPServer{{Address: "10.0.0.1:3333", Weight: 3, TLS: true}},
+					ProxyProtocol:    &ProxyProtocol{Version: 2},
+					ServersTransport: "relay-transport",
+				}},
+			},
+			Middlewares: map[string]*TCPMiddleware{
+				"conns": {InFlightConn: &InFlightConn{Amount: 100}},
+				"allow": {IPAllowList: &IPAllowList{SourceRange: []string{"10.0.0.0/8"}}},
+			},
+			ServersTransports: map[string]*TCPServersTransport{
+				"relay-transport": {
+					DialTimeout:   "5s",
+					DialKeepAlive: "15s",
+					TLS:           &TLSClientConfig{ServerName: "relay", InsecureSkipVerify: true, RootCAs: []string{"/ca.pem"}},
+				},
+			},
+		},
+		UDP: &UDPConfiguration{
+			Routers: map[string]*UDPRouter{
+				"dns": {EntryPoints: []string{"udp"}, Service: "dns"},
+			},
+			Services: map[string]*UDPService{
+				"dns": {LoadBalancer: &UDPLoadBalancer{Servers: []UDPServer{{Address: "10.0.0.53:53"}}}},
+			},
+		},
+	}
+}
+
+func TestConfigurationRoundTrip(t *testing.T) {
+	want := fullConfiguration()
+	if err := want.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var got Configuration
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("round trip mismatch\ngot:  %+v\nwant: %+v", got, *want)
+	}
+}
+
+func TestTCPLoadBalancerOmitsMethod(t *testing.T) {
+	data, err := json.Marshal(&TCPLoadBalancer{Method: "leastconn", Servers: []TCPServer{{Address: "10.0.0.1:3333"}}})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if strings.Contains(string(data), "method") {
+		t.Errorf("payload = %s, want no method outside Traefik's TCP schema", data)
+	}
+}
+
+func TestConfigurationFromTraefikJSON(t *testing.T) {
+	// A payload as written against Traefik's dynamic configuration reference
+	const payload = `{
+		"tcp": {
+			"routers": {
+				"relay-router": {
+					"entryPoints": ["tcp"],
+					"rule": "HostSNI(` + "`*`" + `)",
+					"service": "relay-service",
+					"tls": {"passthrough": true}
+				}
+			},
+			"services": {
+				"relay-service": {
+					"loadBalancer": {
+						"servers": [{"address": "10.0.0.1:3333"}, {"address": "10.0.0.2:3333", "weight": 2}],
+						"proxyProtocol": {"version": 1}
+					}
+				}
+			}
+		},
+		"udp": {
+			"routers": {"dns": {"entryPoints": ["dns"], "service": "dns"}},
+			"services": {"dns": {"loadBalancer": {"servers": [{"address": "10.0.0.53:53"}]}}}
+		}
+	}`
+
+	var cfg Configuration
+	if err := json.Unmarshal([]byte(payload), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	out, err := json.Marshal(&cfg)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var want, got any
+	_ = json.Unmarshal([]byte(payload), &want)
+	_ = json.Unmarshal(out, &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("re-encoded payload differs\ngot:  %s", out)
+	}
+}
+
+func TestConfigurationValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Configuration)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*Configuration) {},
+		},
+		{
+			name:    "router with unknown service",
+			mutate:  func(c *Configuration) { c.TCP.Routers["relay"].Service = "missing" },
+			wantErr: `tcp router relay: unknown service "missing"`,
+		},
+		{
+			name:   "service from another provider",
+			mutate: func(c *Configuration) { c.TCP.Routers["relay"].Service = "relay@file" },
+		},
+		{
+			name:    "router without rule",
+			mutate:  func(c *Configuration) { c.HTTP.Routers["web"].Rule = "" },
+			wantErr: "http router web: rule is required",
+		},
+		{
+			name:    "unknown middleware",
+			mutate:  func(c *Configuration) { c.HTTP.Routers["web"].Middlewares = []string{"nope"} },
+			wantErr: `http router web: unknown middleware "nope"`,
+		},
+		{
+			name: "service with both load balancer and weighted",
+			mutate: func(c *Configuration) {
+				c.TCP.Services["relay"].Weighted = &WeightedRoundRobin{Services: []WeightedService{{Name: "relay"}}}
+			},
+			wantErr: "tcp service relay: exactly one of loadBalancer and weighted is required",
+		},
+		{
+			name:    "server without address",
+			mutate:  func(c *Configuration) { c.UDP.Services["dns"].LoadBalancer.Servers[0].Address = "" },
+			wantErr: "udp service dns: server 0 has no address",
+		},
+		{
+			name:    "weighted unknown service",
+			mutate:  func(c *Configuration) { c.HTTP.Services["web-canary"].Weighted.Services[1].Name = "gone" },
+			wantErr: `http service web-canary: unknown weighted service "gone"`,
+		},
+		{
+			name:    "invalid proxy protocol",
+			mutate:  func(c *Configuration) { c.TCP.Services["relay"].LoadBalancer.ProxyProtocol.Version = 3 },
+			wantErr: "tcp service relay: proxy protocol version must be 1 or 2",
+		},
+		{
+			name: "middleware with two types",
+			mutate: func(c *Configuration) {
+				c.TCP.Middlewares["conns"].IPAllowList = &IPAllowList{SourceRange: []string{"0.0.0.0/0"}}
+			},
+			wantErr: "tcp middleware conns: exactly one middleware type is required, got 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := fullConfiguration()
+			tt.mutate(cfg)
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderPayload(t *testing.T) {
+	b := newTestBackend(t, &fakeTraefik{})
+	payload, err := b.render(context.Background(), []discovery.Backend{
+		{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"},
+		{Group: config.DefaultGroupName, Address: "10.0.0.2:3333", Weight: 5},
+	})
+	if err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+
+	const want = `{"tcp":{"routers":{"relay-router":{"entryPoints":["tcp"],"service":"relay-service","rule":"HostSNI(` +
+		"`*`" + `)"}},"services":{"relay-service":{"loadBalancer":{"servers":[` +
+		`{"address":"10.0.0.1:3333"},{"address":"10.0.0.2:3333","weight":5}]}}}}}`
+	if string(payload) != want {
+		t.Errorf("render() =\n%s\nwant\n%s", payload, want)
+	}
+}
+
+func TestRenderHTTPPayload(t *testing.T) {
+	passHost := true
+	b := newTestBackend(t, &fakeTraefik{}, config.Group{
+		Name:           "api",
+		Protocol:       config.ProtocolHTTP,
+		EntryPoints:    []string{"web"},
+		Rule:           "Host(`api.internal`) && PathPrefix(`/v1`)",
+		RouterName:     "api-router",
+		ServiceName:    "api-service",
+		PassHostHeader: &passHost,
+	})
+	payload, err := b.render(context.Background(), []discovery.Backend{
+		{Group: "api", Address: "10.0.0.1:8080"},
+		{Group: "api", Address: "10.0.0.2:8080", Weight: 2},
+	})
+	if err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+
+	const want = `{"http":{"routers":{"api-router":{"entryPoints":["web"],"service":"api-service","rule":"Host(` +
+		"`api.internal`) \\u0026\\u0026 PathPrefix(`/v1`)" + `"}},"services":{"api-service":{"loadBalancer":{"servers":[` +
+		`{"url":"http://10.0.0.1:8080"},{"url":"http://10.0.0.2:8080","weight":2}],"passHostHeader":true}}}}}`
+	if string(payload) != want {
+		t.Errorf("render() =\n%s\nwant\n%s", payload, want)
+	}
+}
+
+func TestRenderSNIGroups(t *testing.T) {
+	b := newTestBackend(t, &fakeTraefik{},
+		config.Group{
+			Name:        "mqtt",
+			EntryPoints: []string{"tls"},
+			Rule:        "HostSNI(`mqtt.example.com`)",
+			RouterName:  "mqtt-router",
+			ServiceName: "mqtt-service",
+			SNIHosts:    []string{"mqtt.example.com"},
+			TLS:         &config.GroupTLS{Passthrough: true},
+		},
+		config.Group{
+			Name:        "relay",
+			EntryPoints: []string{"tls"},
+			Rule:        "HostSNI(`relay.example.com`)",
+			Priority:    10,
+			RouterName:  "relay-router",
+			ServiceName: "relay-service",
+			SNIHosts:    []string{"relay.example.com"},
+			TLS:         &config.GroupTLS{Options: "modern", CertResolver: "le"},
+		},
+	)
+	cfg := b.buildConfiguration([]discovery.Backend{
+		{Group: "mqtt", Address: "10.0.0.1:8883"},
+		{Group: "relay", Address: "10.0.0.2:3333"},
+	})
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	mqtt := cfg.TCP.Routers["mqtt-router"]
+	if mqtt.TLS == nil || !mqtt.TLS.Passthrough {
+		t.Errorf("mqtt router TLS = %+v, want passthrough", mqtt.TLS)
+	}
+	relay := cfg.TCP.Routers["relay-router"]
+	if relay.TLS == nil || relay.TLS.Passthrough || relay.TLS.Options != "modern" || relay.TLS.CertResolver != "le" {
+		t.Errorf("relay router TLS = %+v, want termination with options and resolver", relay.TLS)
+	}
+	if relay.Rule != "HostSNI(`relay.example.com`)" || relay.Priority != 10 {
+		t.Errorf("relay rule = %s, priority = %d", relay.Rule, relay.Priority)
+	}
+}
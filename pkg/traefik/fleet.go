@@ -0,0 +1,397 @@
+package traefik
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// Fleet gauges
+const (
+	MetricTargets          = "traefik_targets"
+	MetricTargetsConverged = "traefik_targets_converged"
+	MetricTargetsHealthy   = "traefik_targets_healthy"
+)
+
+// ErrNoTargets is returned while no Traefik instance is known
+var ErrNoTargets = errors.New("no Traefik targets")
+
+// Fleet pushes the same configuration to several Traefik instances. Every
+// target is a Backend of its own, with its own circuit breaker, health and
+// convergence, so one unreachable Traefik doesn't hold back the others.
+type Fleet struct {
+	cfg       *config.Config
+	transport http.RoundTripper
+
+	mu            sync.RWMutex
+	targets       map[string]*fleetTarget // By API URL
+	canaryWeights map[string]int
+	lastPayload   []byte
+}
+
+// fleetTarget is one Traefik instance of a fleet and its last known status
+type fleetTarget struct {
+	backend *Backend
+
+	mu          sync.Mutex
+	converged   bool // The last update or restore succeeded
+	lastError   error
+	lastAttempt time.Time
+	healthErr   error
+	healthAt    time.Time
+}
+
+// TargetStatus describes one Traefik instance of a fleet
+type TargetStatus struct {
+	URL                 string    `json:"url"`
+	Converged           bool      `json:"converged"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastAttempt         time.Time `json:"lastAttempt,omitempty"`
+	Healthy             bool      `json:"healthy"`
+	HealthError         string    `json:"healthError,omitempty"`
+	CircuitBreakerState string    `json:"circuitBreakerState"`
+}
+
+// NewFleet creates a fleet pushing to the given Traefik REST provider URLs
+// through transport. More targets can be added later with SetTargets.
+func NewFleet(cfg *config.Config, transport http.RoundTripper, urls []string) *Fleet {
+	canaryWeights := make(map[string]int)
+	for _, g := range cfg.BackendGroups() {
+		if g.Canary != nil {
+			canaryWeights[g.Name] = g.Canary.Weight
+			metrics.SetLabeled(MetricCanaryWeight, g.Name, int64(g.Canary.Weight))
+		}
+	}
+
+	f := &Fleet{
+		cfg:           cfg,
+		transport:     transport,
+		targets:       make(map[string]*fleetTarget),
+		canaryWeights: canaryWeights,
+	}
+	f.SetTargets(urls)
+	return f
+}
+
+// SetTargets replaces the Traefik instances to push to and reports whether
+// the set changed. New targets receive the configuration on the next update.
+func (f *Fleet) SetTargets(urls []string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	wanted := make(map[string]bool, len(urls))
+	var added, removed []string
+	for _, url := range urls {
+		wanted[url] = true
+		if _, ok := f.targets[url]; ok {
+			continue
+		}
+		cfg := *f.cfg
+		cfg.TraefikAPIURL = url
+		backend := NewWithTransport(&cfg, f.transport)
+		backend.setCanaryWeights(f.canaryWeights)
+		f.targets[url] = &fleetTarget{backend: backend}
+		added = append(added, url)
+	}
+	for url := range f.targets {
+		if !wanted[url] {
+			delete(f.targets, url)
+			removed = append(removed, url)
+		}
+	}
+
+	metrics.Set(MetricTargets, int64(len(f.targets)))
+	if len(added) == 0 && len(removed) == 0 {
+		return false
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	slog.Info("Traefik targets changed",
+		"added", added,
+		"removed", removed,
+		"target_count", len(f.targets))
+	return true
+}
+
+// snapshotTargets returns the current targets, sorted by URL
+func (f *Fleet) snapshotTargets() []*fleetTarget {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	urls := slices.Sorted(maps.Keys(f.targets))
+	targets := make([]*fleetTarget, len(urls))
+	for i, url := range urls {
+		targets[i] = f.targets[url]
+	}
+	return targets
+}
+
+// each runs fn on every target concurrently and joins the errors, each
+// prefixed with its target
+func (f *Fleet) each(fn func(t *fleetTarget) error) error {
+	targets := f.snapshotTargets()
+	if len(targets) == 0 {
+		return ErrNoTargets
+	}
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(t); err != nil {
+				errs[i] = fmt.Errorf("target %s: %w", t.backend.apiURL, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// UpdateBackends pushes the backends to every target. It only fails when no
+// target converged. Failed targets are logged and reported by CheckDrift, so
+// the next reconcile retries them while converged ones skip the unchanged payload.
+func (f *Fleet) UpdateBackends(ctx context.Context, backends []discovery.Backend) error {
+	err := f.each(func(t *fleetTarget) error {
+		return t.record(t.backend.UpdateBackends(ctx, backends))
+	})
+	if converged := f.updateConvergence(); err != nil && converged > 0 {
+		slog.Warn("Failed to update some Traefik targets",
+			"error", err,
+			"converged", converged)
+		return nil
+	}
+	return err
+}
+
+// Restore pushes a previously rendered configuration to every target
+func (f *Fleet) Restore(ctx context.Context, payload []byte) error {
+	err := f.each(func(t *fleetTarget) error {
+		// Retries leave alone the targets that already took the snapshot
+		if t.isConverged() {
+			return nil
+		}
+		return t.record(t.backend.Restore(ctx, payload))
+	})
+	f.updateConvergence()
+	return err
+}
+
+// isConverged reports whether the last push to the target succeeded
+func (t *fleetTarget) isConverged() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.converged
+}
+
+// record remembers the outcome of a push
+func (t *fleetTarget) record(err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.converged = err == nil
+	t.lastError = err
+	t.lastAttempt = time.Now()
+	return err
+}
+
+// updateConvergence refreshes the converged gauge and the last accepted
+// payload, and returns the number of converged targets
+func (f *Fleet) updateConvergence() int {
+	targets := f.snapshotTargets()
+	converged := 0
+	var payload []byte
+	for _, t := range targets {
+		t.mu.Lock()
+		if t.converged {
+			converged++
+			payload = t.backend.LastApplied()
+		}
+		t.mu.Unlock()
+	}
+	metrics.Set(MetricTargetsConverged, int64(converged))
+
+	if converged > 0 {
+		f.mu.Lock()
+		f.lastPayload = payload
+		f.mu.Unlock()
+	}
+	return converged
+}
+
+// LastApplied returns the last configuration the converged targets accepted
+func (f *Fleet) LastApplied() []byte {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.lastPayload
+}
+
+// HealthCheck checks every target and fails only when none is healthy
+func (f *Fleet) HealthCheck(ctx context.Context) error {
+	healthy := 0
+	err := f.each(func(t *fleetTarget) error {
+		err := t.backend.HealthCheck(ctx)
+		t.mu.Lock()
+		t.healthErr = err
+		t.healthAt = time.Now()
+		t.mu.Unlock()
+		return err
+	})
+	for _, t := range f.snapshotTargets() {
+		t.mu.Lock()
+		if t.healthErr == nil && !t.healthAt.IsZero() {
+			healthy++
+		}
+		t.mu.Unlock()
+	}
+	metrics.Set(MetricTargetsHealthy, int64(healthy))
+
+	if healthy > 0 {
+		return nil
+	}
+	return err
+}
+
+// CheckDrift checks every target. Targets that didn't converge, e.g. newly
+// discovered ones or ones whose last push failed, are reported as drifted
+// once any target has accepted a push.
+func (f *Fleet) CheckDrift(ctx context.Context) ([]string, error) {
+	applied := f.LastApplied() != nil
+	var mu sync.Mutex
+	var drift []string
+	err := f.each(func(t *fleetTarget) error {
+		if applied && !t.isConverged() {
+			mu.Lock()
+			defer mu.Unlock()
+			drift = append(drift, fmt.Sprintf("target %s: not converged", t.backend.apiURL))
+			return nil
+		}
+		targetDrift, err := t.backend.CheckDrift(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, d := range targetDrift {
+			drift = append(drift, fmt.Sprintf("target %s: %s", t.backend.apiURL, d))
+		}
+		return err
+	})
+	if errors.Is(err, ErrNoTargets) {
+		return nil, nil
+	}
+	if len(drift) > 0 {
+		// Targets that can't be checked must not keep the others from converging
+		if err != nil {
+			slog.Warn("Failed to check some Traefik targets for drift", "error", err)
+		}
+		slices.Sort(drift)
+		return drift, nil
+	}
+	return nil, err
+}
+
+// Targets describes every target
+func (f *Fleet) Targets() []TargetStatus {
+	targets := f.snapshotTargets()
+	statuses := make([]TargetStatus, 0, len(targets))
+	for _, t := range targets {
+		t.mu.Lock()
+		status := TargetStatus{
+			URL:                 t.backend.apiURL,
+			Converged:           t.converged,
+			LastAttempt:         t.lastAttempt,
+			Healthy:             t.healthErr == nil && !t.healthAt.IsZero(),
+			CircuitBreakerState: t.backend.circuitBreaker.State(),
+		}
+		if t.lastError != nil {
+			status.LastError = t.lastError.Error()
+		}
+		if t.healthErr != nil {
+			status.HealthError = t.healthErr.Error()
+		}
+		t.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// TargetsHandler returns an HTTP handler listing every target and its status
+func (f *Fleet) TargetsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(f.Targets())
+	})
+}
+
+// APIPort returns the port of a Traefik API URL, defaulting by scheme
+func APIPort(apiURL string) int {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return 0
+	}
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+	if u.Scheme == "https" {
+		return 443
+	}
+	return 80
+}
+
+// WithServerName wraps a transport factory so TLS certificates are verified
+// against the host of apiURL instead of the dialled address, as needed for the
+// pod IPs of PodURLs
+func WithServerName(apiURL string, newTransport func(*tls.Config) *http.Transport) func(*tls.Config) *http.Transport {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return newTransport
+	}
+	return func(tlsConfig *tls.Config) *http.Transport {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		tlsConfig.ServerName = u.Hostname()
+		return newTransport(tlsConfig)
+	}
+}
+
+// PodURLs addresses the API of every discovered Traefik pod like apiURL, with
+// the host replaced by the pod's ip:port. Over HTTPS the transport needs
+// WithServerName, since the certificate names the host, not the pod IPs.
+func PodURLs(apiURL string, pods []discovery.Backend) ([]string, error) {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Traefik API URL: %w", err)
+	}
+	urls := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		if _, _, err := net.SplitHostPort(pod.Address); err != nil {
+			return nil, fmt.Errorf("invalid pod address %q: %w", pod.Address, err)
+		}
+		podURL := *u
+		podURL.Host = pod.Address
+		urls = append(urls, podURL.String())
+	}
+	slices.Sort(urls)
+	return slices.Compact(urls), nil
+}
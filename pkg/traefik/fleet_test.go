@@ -0,0 +1,305 @@
+package traefik
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+// newFleetTarget starts a stub Traefik and returns its REST provider URL
+func newFleetTarget(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv.URL + "/api/providers/rest"
+}
+
+func newTestFleet(urls ...string) *Fleet {
+	cfg := testConfig("")
+	cfg.TraefikAPIURL = ""
+	return NewFleet(cfg, NewTransport(nil), urls)
+}
+
+func TestFleetUpdateBackends(t *testing.T) {
+	a, b := &fakeTraefik{}, &fakeTraefik{}
+	f := newTestFleet(newFleetTarget(t, a), newFleetTarget(t, b))
+	ctx := context.Background()
+
+	backends := []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}
+	if err := f.UpdateBackends(ctx, backends); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	for name, fake := range map[string]*fakeTraefik{"a": a, "b": b} {
+		if fake.putCount != 1 {
+			t.Errorf("%s PUTs = %d, want 1", name, fake.putCount)
+		}
+	}
+	if f.LastApplied() == nil {
+		t.Error("LastApplied() = nil after every target converged")
+	}
+	for _, status := range f.Targets() {
+		if !status.Converged {
+			t.Errorf("target %s not converged", status.URL)
+		}
+	}
+}
+
+func TestFleetFailingTarget(t *testing.T) {
+	good := &fakeTraefik{}
+	goodURL := newFleetTarget(t, good)
+	badURL := newFleetTarget(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	f := newTestFleet(goodURL, badURL)
+
+	// One converged target is enough for the update to succeed
+	if err := f.UpdateBackends(context.Background(), []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if good.putCount != 1 {
+		t.Errorf("good target PUTs = %d, want 1", good.putCount)
+	}
+	if f.LastApplied() == nil {
+		t.Error("LastApplied() = nil although a target converged")
+	}
+
+	statuses := f.Targets()
+	if len(statuses) != 2 {
+		t.Fatalf("Targets() = %+v, want 2", statuses)
+	}
+	for _, status := range statuses {
+		if want := status.URL == goodURL; status.Converged != want {
+			t.Errorf("target %s converged = %v, want %v", status.URL, status.Converged, want)
+		}
+		if status.URL == badURL && status.LastError == "" {
+			t.Errorf("target %s has no last error", status.URL)
+		}
+	}
+}
+
+func TestFleetFailingTargetDoesNotBlockOthers(t *testing.T) {
+	good := &fakeTraefik{}
+	goodURL := newFleetTarget(t, good)
+	badURL := newFleetTarget(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	f := newTestFleet(goodURL, badURL)
+	ctx := context.Background()
+	backends := []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}
+
+	if err := f.UpdateBackends(ctx, backends); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	// The failing target stays drifted, so every reconcile retries it
+	drift, err := f.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 1 || !strings.Contains(drift[0], badURL) {
+		t.Errorf("drift = %v, want only %s not converged", drift, badURL)
+	}
+
+	// A restarted healthy target is still detected and re-pushed
+	good.restart()
+	drift, err = f.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() after restart error = %v", err)
+	}
+	if !slices.ContainsFunc(drift, func(d string) bool { return strings.Contains(d, goodURL) }) {
+		t.Errorf("drift after restart = %v, want %s drifted", drift, goodURL)
+	}
+	if err := f.UpdateBackends(ctx, backends); err != nil {
+		t.Fatalf("UpdateBackends() after restart error = %v", err)
+	}
+	if good.putCount != 2 {
+		t.Errorf("good target PUTs = %d, want the restarted target re-pushed", good.putCount)
+	}
+	if drift, _ := f.CheckDrift(ctx); len(drift) != 1 || !strings.Contains(drift[0], badURL) {
+		t.Errorf("drift after re-push = %v, want only %s not converged", drift, badURL)
+	}
+}
+
+func TestFleetRestoreSkipsConverged(t *testing.T) {
+	good, flaky := &fakeTraefik{}, &fakeTraefik{}
+	failures := 1
+	flakyURL := newFleetTarget(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		flaky.ServeHTTP(w, r)
+	}))
+	f := newTestFleet(newFleetTarget(t, good), flakyURL)
+	payload := []byte(`{"tcp":{"routers":{"relay-router":{"service":"relay-service","rule":"HostSNI(` + "`*`" + `)"}},` +
+		`"services":{"relay-service":{"loadBalancer":{"servers":[{"address":"10.0.0.1:3333"}]}}}}}`)
+
+	ctx := context.Background()
+	if err := f.Restore(ctx, payload); err == nil {
+		t.Fatal("Restore() should fail while a target is unavailable")
+	}
+	if err := f.Restore(ctx, payload); err != nil {
+		t.Fatalf("Restore() retry error = %v", err)
+	}
+	if good.putCount != 1 || flaky.putCount != 1 {
+		t.Errorf("PUTs = %d and %d, want the converged target left alone on retry", good.putCount, flaky.putCount)
+	}
+	if string(f.LastApplied()) != string(payload) {
+		t.Errorf("LastApplied() = %s, want the snapshot", f.LastApplied())
+	}
+}
+
+func TestFleetSetTargets(t *testing.T) {
+	a, b := &fakeTraefik{}, &fakeTraefik{}
+	aURL, bURL := newFleetTarget(t, a), newFleetTarget(t, b)
+	f := newTestFleet(aURL)
+	ctx := context.Background()
+	backends := []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}
+
+	if err := f.UpdateBackends(ctx, backends); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if !f.SetTargets([]string{aURL, bURL}) {
+		t.Error("SetTargets() = false after adding a target")
+	}
+	if f.SetTargets([]string{bURL, aURL}) {
+		t.Error("SetTargets() = true for the same targets")
+	}
+
+	// The new target is reported as drifted until it is configured
+	drift, err := f.CheckDrift(ctx)
+	if err != nil {
+		t.Fatalf("CheckDrift() error = %v", err)
+	}
+	if len(drift) != 1 || !strings.Contains(drift[0], bURL) {
+		t.Errorf("CheckDrift() = %v, want the new target", drift)
+	}
+
+	// Only the new target receives the unchanged configuration
+	if err := f.UpdateBackends(ctx, backends); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if a.putCount != 1 || b.putCount != 1 {
+		t.Errorf("PUTs = %d, %d, want 1, 1", a.putCount, b.putCount)
+	}
+
+	if !f.SetTargets([]string{bURL}) {
+		t.Error("SetTargets() = false after removing a target")
+	}
+	if got := f.Targets(); len(got) != 1 || got[0].URL != bURL {
+		t.Errorf("Targets() = %+v, want only %s", got, bURL)
+	}
+
+	f.SetTargets(nil)
+	if err := f.UpdateBackends(ctx, backends); err != ErrNoTargets {
+		t.Errorf("UpdateBackends() without targets error = %v, want ErrNoTargets", err)
+	}
+}
+
+func TestFleetHealthCheck(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	down := httptest.NewServer(ok)
+	downURL := down.URL + "/api/providers/rest"
+	down.Close()
+
+	f := newTestFleet(newFleetTarget(t, ok), downURL)
+	if err := f.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck() error = %v, want healthy with one target up", err)
+	}
+
+	f.SetTargets([]string{downURL})
+	if err := f.HealthCheck(context.Background()); err == nil {
+		t.Error("HealthCheck() = nil with every target down")
+	}
+}
+
+func TestFleetCanaryWeight(t *testing.T) {
+	a, b := &fakeTraefik{}, &fakeTraefik{}
+	cfg := testConfig("", canaryGroup(config.ProtocolTCP))
+	f := NewFleet(cfg, NewTransport(nil), []string{newFleetTarget(t, a), newFleetTarget(t, b)})
+
+	if err := f.SetCanaryWeight("relay", 30); err != nil {
+		t.Fatalf("SetCanaryWeight() error = %v", err)
+	}
+	if err := f.SetCanaryWeight("missing", 30); err == nil {
+		t.Error("SetCanaryWeight() for a group without canary should fail")
+	}
+	backends := []discovery.Backend{
+		{Group: "relay", Address: "10.0.0.1:3333"},
+		{Group: "relay", Address: "10.0.0.2:3333", Canary: true},
+	}
+	if err := f.UpdateBackends(context.Background(), backends); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	for name, fake := range map[string]*fakeTraefik{"a": a, "b": b} {
+		body, _ := json.Marshal(fake.config)
+		var got Configuration
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("%s: invalid config: %v", name, err)
+		}
+		service := got.TCP.Services["relay-service"]
+		if service == nil {
+			t.Fatalf("%s: missing weighted service", name)
+		}
+		if w := weights(service.Weighted); w["relay-service-canary"] != 30 {
+			t.Errorf("%s: weights = %v, want canary 30", name, w)
+		}
+	}
+}
+
+func TestPodURLs(t *testing.T) {
+	pods := []discovery.Backend{{Address: "10.0.0.2:8080"}, {Address: "10.0.0.1:8080"}}
+	got, err := PodURLs("http://traefik.kube-system:8080/api/providers/rest", pods)
+	if err != nil {
+		t.Fatalf("PodURLs() error = %v", err)
+	}
+	want := []string{"http://10.0.0.1:8080/api/providers/rest", "http://10.0.0.2:8080/api/providers/rest"}
+	if !slices.Equal(got, want) {
+		t.Errorf("PodURLs() = %v, want %v", got, want)
+	}
+
+	for apiURL, want := range map[string]int{
+		"http://traefik:8080/api/providers/rest": 8080,
+		"https://traefik/api/providers/rest":     443,
+		"http://traefik/api/providers/rest":      80,
+	} {
+		if got := APIPort(apiURL); got != want {
+			t.Errorf("APIPort(%q) = %d, want %d", apiURL, got, want)
+		}
+	}
+}
+
+func TestWithServerName(t *testing.T) {
+	srv := httptest.NewTLSServer(&fakeTraefik{})
+	t.Cleanup(srv.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	// The pod is dialled by IP, like a URL from PodURLs
+	podURL := srv.URL + "/api/rawdata"
+
+	for apiURL, wantErr := range map[string]bool{
+		"https://example.com/api/providers/rest":     false, // Named in the test certificate
+		"https://traefik.invalid/api/providers/rest": true,
+	} {
+		newTransport := WithServerName(apiURL, NewTransport)
+		client := &http.Client{Transport: newTransport(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12})}
+		resp, err := client.Get(podURL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != wantErr {
+			t.Errorf("GET with server name of %s: error = %v, wantErr %v", apiURL, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// MetricMergeConflicts counts pushes that overwrote an owned entry another writer had changed
+const MetricMergeConflicts = "traefik_merge_conflicts_total"
+
+// rawDataSections maps /api/rawdata keys to the protocol and kind of the
+// REST provider payload they come from
+var rawDataSections = map[string][2]string{
+	"routers":              {"http", "routers"},
+	"services":             {"http", "services"},
+	"middlewares":          {"http", "middlewares"},
+	"serversTransports":    {"http", "serversTransports"},
+	"tcpRouters":           {"tcp", "routers"},
+	"tcpServices":          {"tcp", "services"},
+	"tcpMiddlewares":       {"tcp", "middlewares"},
+	"tcpServersTransports": {"tcp", "serversTransports"},
+	"udpRouters":           {"udp", "routers"},
+	"udpServices":          {"udp", "services"},
+}
+
+// runtimeFields are added by Traefik to /api/rawdata entries and aren't part
+// of the dynamic configuration
+var runtimeFields = []string{"status", "using", "error", "usedBy", "serverStatus", "provider"}
+
+// document is a REST provider payload as protocol -> kind -> name -> entry,
+// so entries of other writers pass through without loss
+type document map[string]map[string]map[string]json.RawMessage
+
+// parseDocument splits a rendered payload into its entries
+func parseDocument(payload []byte) (document, error) {
+	doc := document{}
+	if len(payload) == 0 {
+		return doc, nil
+	}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse payload: %w", err)
+	}
+	return doc, nil
+}
+
+// get returns an entry, if present
+func (d document) get(protocol, kind, name string) (json.RawMessage, bool) {
+	entry, ok := d[protocol][kind][name]
+	return entry, ok
+}
+
+// set adds or replaces an entry
+func (d document) set(protocol, kind, name string, entry json.RawMessage) {
+	if d[protocol] == nil {
+		d[protocol] = map[string]map[string]json.RawMessage{}
+	}
+	if d[protocol][kind] == nil {
+		d[protocol][kind] = map[string]json.RawMessage{}
+	}
+	d[protocol][kind][name] = entry
+}
+
+// liveDocument reads the entries the REST provider currently serves
+func (b *Backend) liveDocument(ctx context.Context) (document, error) {
+	var raw map[string]map[string]json.RawMessage
+	if err := b.getRawData(ctx, &raw); err != nil {
+		return nil, err
+	}
+
+	doc := document{}
+	for key, entries := range raw {
+		section, ok := rawDataSections[key]
+		if !ok {
+			continue
+		}
+		for qualified, entry := range entries {
+			name, ok := strings.CutSuffix(qualified, restProviderSuffix)
+			if !ok {
+				continue
+			}
+			cleaned, err := stripRuntimeFields(entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s %s: %w", key, qualified, err)
+			}
+			doc.set(section[0], section[1], name, cleaned)
+		}
+	}
+	return doc, nil
+}
+
+// stripRuntimeFields drops the fields Traefik adds to /api/rawdata entries
+func stripRuntimeFields(entry json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range runtimeFields {
+		delete(fields, field)
+	}
+	return json.Marshal(fields)
+}
+
+// owns reports whether this instance owns an entry: it rendered it in one of
+// the recent pushes, or its name carries the owner prefix
+func (b *Backend) owns(pushed []document, protocol, kind, name string) bool {
+	if b.ownerPrefix != "" && strings.HasPrefix(name, b.ownerPrefix) {
+		return true
+	}
+	for _, doc := range pushed {
+		if _, ok := doc.get(protocol, kind, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// changedByOthers reports whether a live entry was changed by another writer:
+// both recent pushes rendered it, and it matches neither. Traefik applies
+// pushes asynchronously, so it may still serve the entry of the push before
+// the last one, or the live entry that push adopted.
+func changedByOthers(pushed []document, protocol, kind, name string, live json.RawMessage) bool {
+	for _, doc := range pushed {
+		entry, ok := doc.get(protocol, kind, name)
+		if !ok || sameEntry(protocol, kind, entry, live) {
+			return false
+		}
+	}
+	return len(pushed) > 1
+}
+
+// merge combines the rendered payload with the live entries of other writers.
+// Owned entries that are no longer rendered are removed. An owned entry
+// changed by someone else, e.g. a second balancer configured with the same
+// names, is logged and counted as a conflict, and overwritten.
+//
+// The REST provider has no compare-and-swap: a writer that PUTs between our
+// read and our PUT loses its change. Its own drift detection sees the missing
+// entries and pushes them again on its next reconcile.
+func (b *Backend) merge(ctx context.Context, payload []byte) ([]byte, error) {
+	desired, err := parseDocument(payload)
+	if err != nil {
+		return nil, err
+	}
+	var pushed []document
+	for _, payload := range b.recentPayloads() {
+		doc, err := parseDocument(payload)
+		if err != nil {
+			return nil, err
+		}
+		pushed = append(pushed, doc)
+	}
+	live, err := b.liveDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	kept := 0
+	for protocol, kinds := range live {
+		for kind, entries := range kinds {
+			for name, entry := range entries {
+				if _, ok := desired.get(protocol, kind, name); ok {
+					// Entries never pushed by this instance are adopted, e.g. after a restart
+					if changedByOthers(pushed, protocol, kind, name, entry) {
+						conflicts = append(conflicts, fmt.Sprintf("%s %s %s", protocol, kind, name))
+					}
+					continue
+				}
+				if b.owns(pushed, protocol, kind, name) {
+					continue
+				}
+				desired.set(protocol, kind, name, entry)
+				kept++
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		slices.Sort(conflicts)
+		metrics.Inc(MetricMergeConflicts)
+		slog.Warn("Overwriting Traefik entries changed by another writer", "entries", conflicts)
+	}
+
+	merged, err := json.Marshal(desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal merged config: %w", err)
+	}
+	slog.Debug("Merged Traefik configuration", "foreign_entries", kept)
+	return merged, nil
+}
+
+// sameEntry compares the parts of two entries Traefik echoes back reliably:
+// rule, service and entrypoints of routers, and servers of services. Other
+// kinds always compare equal.
+func sameEntry(protocol, kind string, a, b json.RawMessage) bool {
+	switch kind {
+	case "routers":
+		var ra, rb struct {
+			Rule        string   `json:"rule"`
+			Service     string   `json:"service"`
+			EntryPoints []string `json:"entryPoints"`
+		}
+		if json.Unmarshal(a, &ra) != nil || json.Unmarshal(b, &rb) != nil {
+			return false
+		}
+		return ra.Rule == rb.Rule &&
+			strings.TrimSuffix(ra.Service, restProviderSuffix) == strings.TrimSuffix(rb.Service, restProviderSuffix) &&
+			sameSet(ra.EntryPoints, rb.EntryPoints)
+	case "services":
+		sa, errA := serverList(protocol, a)
+		sb, errB := serverList(protocol, b)
+		return errA == nil && errB == nil && sameSet(sa, sb)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,232 @@
+package traefik
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+func tcpGroup(name string) config.Group {
+	return config.Group{
+		Name:        name,
+		EntryPoints: []string{"tcp"},
+		Rule:        "HostSNI(`*`)",
+		RouterName:  name + "-router",
+		ServiceName: name + "-service",
+	}
+}
+
+// newMergeBackend creates a merging backend sharing the stub at url
+func newMergeBackend(url, ownerPrefix string, groups ...config.Group) *Backend {
+	cfg := testConfig(url, groups...)
+	cfg.MergeUpdates = true
+	cfg.OwnerPrefix = ownerPrefix
+	return New(cfg)
+}
+
+// tcpRouters lists the TCP routers the stub was last sent
+func (f *fakeTraefik) tcpRouters() map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tcp, _ := f.config["tcp"].(map[string]any)
+	routers, _ := tcp["routers"].(map[string]any)
+	return routers
+}
+
+func TestMergeKeepsOtherWriters(t *testing.T) {
+	fake := &fakeTraefik{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	a := newMergeBackend(srv.URL, "", tcpGroup("relay-a"))
+	b := newMergeBackend(srv.URL, "", tcpGroup("relay-b"))
+
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay-a", Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("a.UpdateBackends() error = %v", err)
+	}
+	if err := b.UpdateBackends(ctx, []discovery.Backend{{Group: "relay-b", Address: "10.0.1.1:3333"}}); err != nil {
+		t.Fatalf("b.UpdateBackends() error = %v", err)
+	}
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay-a", Address: "10.0.0.2:3333"}}); err != nil {
+		t.Fatalf("a.UpdateBackends() error = %v", err)
+	}
+
+	routers := fake.tcpRouters()
+	if routers["relay-a-router"] == nil || routers["relay-b-router"] == nil {
+		t.Fatalf("routers = %v, want both writers' routers", routers)
+	}
+
+	// Each writer still sees only its own entries as drift-relevant
+	for name, backend := range map[string]*Backend{"a": a, "b": b} {
+		drift, err := backend.CheckDrift(ctx)
+		if err != nil || len(drift) != 0 {
+			t.Errorf("%s.CheckDrift() = %v, %v, want no drift", name, drift, err)
+		}
+	}
+}
+
+func TestMergeConflict(t *testing.T) {
+	fake := &fakeTraefik{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	// Two writers misconfigured to manage the same router and service
+	a := newMergeBackend(srv.URL, "", tcpGroup("relay"))
+	b := newMergeBackend(srv.URL, "", tcpGroup("relay"))
+
+	for _, addr := range []string{"10.0.0.1:3333", "10.0.0.2:3333"} {
+		if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay", Address: addr}}); err != nil {
+			t.Fatalf("a.UpdateBackends() error = %v", err)
+		}
+	}
+	// b never pushed before, so it adopts what it finds
+	if err := b.UpdateBackends(ctx, []discovery.Backend{{Group: "relay", Address: "10.0.1.1:3333"}}); err != nil {
+		t.Fatalf("b.UpdateBackends() error = %v", err)
+	}
+
+	// The conflict is reported, but doesn't fail the push
+	conflicts := metrics.Get(MetricMergeConflicts)
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay", Address: "10.0.0.3:3333"}}); err != nil {
+		t.Fatalf("a.UpdateBackends() error = %v", err)
+	}
+	if got := metrics.Get(MetricMergeConflicts); got != conflicts+1 {
+		t.Errorf("conflicts = %d, want %d", got, conflicts+1)
+	}
+	fake.mu.Lock()
+	putCount := fake.putCount
+	fake.mu.Unlock()
+	if putCount != 4 {
+		t.Errorf("PUTs = %d, want the conflicting push sent", putCount)
+	}
+	if drift, err := a.CheckDrift(ctx); err != nil || len(drift) != 0 {
+		t.Errorf("a.CheckDrift() = %v, %v, want the last push live", drift, err)
+	}
+}
+
+func TestMergeDuringApply(t *testing.T) {
+	fake := &fakeTraefik{}
+	var pending atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accept the push without applying it yet, like Traefik's asynchronous reload
+		if r.Method == http.MethodPut && pending.CompareAndSwap(true, false) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+	a := newMergeBackend(srv.URL, "", tcpGroup("relay"))
+
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay", Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	pending.Store(true)
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay", Address: "10.0.0.2:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	// Traefik still serving the previous push is no conflict
+	conflicts := metrics.Get(MetricMergeConflicts)
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "relay", Address: "10.0.0.3:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if got := metrics.Get(MetricMergeConflicts); got != conflicts {
+		t.Errorf("conflicts = %d, want %d", got, conflicts)
+	}
+}
+
+func TestMergeRemovesOwnedEntries(t *testing.T) {
+	fake := &fakeTraefik{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	// Left behind by another writer and by a previous run of this one
+	stale := `{"tcp":{"routers":{
+		"team-a-old-router":{"entryPoints":["tcp"],"rule":"HostSNI(` + "`*`" + `)","service":"team-a-old-service"},
+		"other-router":{"entryPoints":["tcp"],"rule":"HostSNI(` + "`other`" + `)","service":"other-service"}},
+		"services":{
+		"team-a-old-service":{"loadBalancer":{"servers":[{"address":"10.9.9.9:3333"}]}},
+		"other-service":{"loadBalancer":{"servers":[{"address":"10.8.8.8:3333"}]}}}}}`
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/api/providers/rest", bytes.NewBufferString(stale))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("seeding stub: %v", err)
+	}
+	resp.Body.Close()
+
+	a := newMergeBackend(srv.URL, "team-a-", tcpGroup("team-a-relay"))
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "team-a-relay", Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	routers := fake.tcpRouters()
+	if routers["team-a-relay-router"] == nil || routers["other-router"] == nil {
+		t.Errorf("routers = %v, want own and foreign routers", routers)
+	}
+	if routers["team-a-old-router"] != nil {
+		t.Errorf("stale owned router was kept: %v", routers)
+	}
+}
+
+func TestMergeDropsRuntimeFields(t *testing.T) {
+	fake := &fakeTraefik{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+
+	b := newMergeBackend(srv.URL, "team-b-", tcpGroup("team-b-relay"))
+	if err := b.UpdateBackends(ctx, []discovery.Backend{{Group: "team-b-relay", Address: "10.0.1.1:3333"}}); err != nil {
+		t.Fatalf("b.UpdateBackends() error = %v", err)
+	}
+	// Traefik reports runtime state next to the configuration in /api/rawdata
+	fake.mu.Lock()
+	for _, key := range []string{"tcpRouters", "tcpServices"} {
+		for _, entry := range fake.rawdata[key].(map[string]any) {
+			fields := entry.(map[string]any)
+			fields["status"] = "disabled"
+			fields["error"] = []string{"invalid rule"}
+			fields["usedBy"] = []string{"team-b-relay-router@rest"}
+			fields["provider"] = "rest"
+		}
+	}
+	fake.mu.Unlock()
+
+	a := newMergeBackend(srv.URL, "team-a-", tcpGroup("team-a-relay"))
+	if err := a.UpdateBackends(ctx, []discovery.Backend{{Group: "team-a-relay", Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("a.UpdateBackends() error = %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	tcp := fake.config["tcp"].(map[string]any)
+	for _, kind := range []string{"routers", "services"} {
+		for name, entry := range tcp[kind].(map[string]any) {
+			for _, field := range runtimeFields {
+				if _, ok := entry.(map[string]any)[field]; ok {
+					t.Errorf("%s %s was written back with runtime field %q", kind, name, field)
+				}
+			}
+		}
+	}
+}
+
+func TestStripRuntimeFields(t *testing.T) {
+	got, err := stripRuntimeFields([]byte(`{"rule":"HostSNI(*)","status":"enabled","using":["tcp"],"error":["bad"],"usedBy":["r@rest"],"provider":"rest"}`))
+	if err != nil {
+		t.Fatalf("stripRuntimeFields() error = %v", err)
+	}
+	if string(got) != `{"rule":"HostSNI(*)"}` {
+		t.Errorf("stripRuntimeFields() = %s", got)
+	}
+}
@@ -0,0 +1,140 @@
+package traefik
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/metrics"
+)
+
+// MetricVerifyFailures counts pushes Traefik didn't report as applied in time
+const MetricVerifyFailures = "traefik_verify_failures_total"
+
+// defaultVerifyInterval is how often Traefik is polled while verifying a push
+const defaultVerifyInterval = 250 * time.Millisecond
+
+// statusEnabled is the status Traefik reports for a working router or service
+const statusEnabled = "enabled"
+
+// entryStatus is the runtime status Traefik reports for a router or service
+type entryStatus struct {
+	Status string   `json:"status"`
+	Errors []string `json:"error"`
+}
+
+// verify polls Traefik until every router and service of the pushed payload
+// is enabled and every service has the pushed servers. The REST provider
+// accepts any syntactically valid payload with a 200 and rejects bad
+// configurations only when applying them, so this is the only way to tell.
+func (b *Backend) verify(ctx context.Context, payload []byte) error {
+	if b.verifyTimeout <= 0 {
+		return nil
+	}
+
+	var cfg Configuration
+	if err := json.Unmarshal(payload, &cfg); err != nil {
+		return fmt.Errorf("failed to parse pushed config: %w", err)
+	}
+	routers, services := desiredState(&cfg)
+
+	ctx, cancel := context.WithTimeout(ctx, b.verifyTimeout)
+	defer cancel()
+	ticker := time.NewTicker(b.verifyInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var mismatches []string
+	for {
+		current := b.checkApplied(ctx, routers, services)
+		// A poll cut short by the timeout says nothing about Traefik
+		if ctx.Err() == nil || mismatches == nil {
+			if len(current) == 0 {
+				slog.Debug("Traefik applied the configuration", "duration", time.Since(start))
+				return nil
+			}
+			mismatches = current
+		}
+
+		select {
+		case <-ctx.Done():
+			metrics.Inc(MetricVerifyFailures)
+			return fmt.Errorf("traefik didn't apply the configuration within %s: %s",
+				b.verifyTimeout, strings.Join(mismatches, "; "))
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkApplied describes every router or service not yet applied as pushed
+func (b *Backend) checkApplied(ctx context.Context, routers map[string]routerState, services map[string]serviceState) []string {
+	var mismatches []string
+	for key := range routers {
+		protocol, name, _ := strings.Cut(key, "/")
+		if _, err := b.fetchEntry(ctx, protocol, "routers", name); err != nil {
+			mismatches = append(mismatches, err.Error())
+		}
+	}
+	for key, want := range services {
+		protocol, name, _ := strings.Cut(key, "/")
+		body, err := b.fetchEntry(ctx, protocol, "services", name)
+		if err != nil {
+			mismatches = append(mismatches, err.Error())
+			continue
+		}
+		got, err := serverList(protocol, body)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s service %s: %v", protocol, name, err))
+			continue
+		}
+		if !sameSet(got, want.Servers) {
+			mismatches = append(mismatches, fmt.Sprintf("%s service %s servers are %v, want %v", protocol, name, got, want.Servers))
+		}
+	}
+	slices.Sort(mismatches)
+	return mismatches
+}
+
+// fetchEntry reads a REST provider router or service from the Traefik API
+// and fails unless it is enabled
+func (b *Backend) fetchEntry(ctx context.Context, protocol, kind, name string) (json.RawMessage, error) {
+	label := protocol + " " + strings.TrimSuffix(kind, "s") + " " + name
+	endpoint := apiEndpoint(b.apiURL, "/"+protocol+"/"+kind+"/"+url.PathEscape(name+restProviderSuffix))
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", label, err)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", label, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s is missing", label)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status %d", label, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", label, err)
+	}
+	var status entryStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("%s: %w", label, err)
+	}
+	if status.Status != statusEnabled {
+		return nil, fmt.Errorf("%s is %s: %s", label, status.Status, strings.Join(status.Errors, ", "))
+	}
+	return body, nil
+}
@@ -0,0 +1,65 @@
+package traefik
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/config"
+	"github.com/tazhate/k8s-internal-loadbalancer/pkg/discovery"
+)
+
+func TestVerifyApplied(t *testing.T) {
+	// Entries only show up a while after the PUT returned
+	fake := &fakeTraefik{applyDelay: 30 * time.Millisecond}
+	b := newTestBackend(t, fake)
+	b.verifyTimeout = time.Second
+	b.verifyInterval = 5 * time.Millisecond
+
+	start := time.Now()
+	if err := b.UpdateBackends(context.Background(), []discovery.Backend{{Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("UpdateBackends() returned after %s, before Traefik applied the push", elapsed)
+	}
+}
+
+func TestVerifyRejected(t *testing.T) {
+	fake := &fakeTraefik{disabled: map[string]bool{"relay-router": true}}
+	b := newTestBackend(t, fake)
+	b.verifyTimeout = 50 * time.Millisecond
+	b.verifyInterval = 5 * time.Millisecond
+
+	err := b.UpdateBackends(context.Background(), []discovery.Backend{{Address: "10.0.0.1:3333"}})
+	if err == nil || !strings.Contains(err.Error(), "tcp router relay-router is disabled") {
+		t.Fatalf("UpdateBackends() error = %v, want the disabled router", err)
+	}
+	if failures := b.CircuitBreakerStats()["consecutive_failures"]; failures != uint32(1) {
+		t.Errorf("circuit breaker failures = %v, want the mismatch counted", failures)
+	}
+}
+
+func TestCheckAppliedServers(t *testing.T) {
+	fake := &fakeTraefik{}
+	b := newTestBackend(t, fake)
+	ctx := context.Background()
+
+	if err := b.UpdateBackends(ctx, []discovery.Backend{{Group: config.DefaultGroupName, Address: "10.0.0.1:3333"}}); err != nil {
+		t.Fatalf("UpdateBackends() error = %v", err)
+	}
+
+	routers := map[string]routerState{protocolKey("tcp", "relay-router"): {}}
+	services := map[string]serviceState{protocolKey("tcp", "relay-service"): {Servers: []string{"10.0.0.1:3333"}}}
+	if mismatches := b.checkApplied(ctx, routers, services); len(mismatches) != 0 {
+		t.Errorf("checkApplied() = %v, want none", mismatches)
+	}
+
+	services[protocolKey("tcp", "relay-service")] = serviceState{Servers: []string{"10.0.0.2:3333"}}
+	services[protocolKey("tcp", "other-service")] = serviceState{}
+	mismatches := b.checkApplied(ctx, routers, services)
+	if len(mismatches) != 2 {
+		t.Errorf("checkApplied() = %v, want a server mismatch and a missing service", mismatches)
+	}
+}